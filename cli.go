@@ -2,7 +2,8 @@ package main
 
 import (
 	"fmt"
-	"log"
+	"os"
+	"strings"
 	workers "yeet/workers"
 
 	"github.com/urfave/cli/v2"
@@ -11,6 +12,42 @@ import (
 // Globally available within the package. Set via the --debug,-d flag
 var debugMode bool = false
 
+// Set via the --restore-stash flag of take
+var restoreStash bool = false
+
+// Set via the --dry-run flag of take
+var dryRun bool = false
+
+// Set via the --strategy flag of take
+var strategy string = ""
+
+// Set via the --keep-conflicts flag of take
+var keepConflicts bool = false
+
+// Set via the --gerrit-topic flag of take
+var gerritTopic string = ""
+
+// Set via the --regex flag of find
+var findRegex bool = false
+
+// Set via the --min-repos flag of topics
+var minRepos int = 2
+
+// Set via the --set-upstream flag of push
+var setUpstream bool = false
+
+// Set via the --changed flag of start
+var onlyChanged bool = false
+
+// Set via the --force flag of sync
+var forceSync bool = false
+
+// Set via the --group-output flag of exec
+var groupOutput bool = false
+
+// Set via the --all flag of stash drop
+var allStashes bool = false
+
 type yCLI struct {
 	args []string
 }
@@ -26,6 +63,66 @@ func (ycli *yCLI) run() {
 			Usage:       "Print debugging information",
 			Destination: &debugMode,
 		},
+		&cli.StringFlag{
+			Name:        "config",
+			Usage:       "Path to the config file, instead of searching for one",
+			Destination: &workers.ConfigPath,
+		},
+		&cli.IntFlag{
+			Name:        "jobs",
+			Aliases:     []string{"j"},
+			Usage:       "Number of repos to work on at once (default from config, else 8)",
+			Destination: &workers.Jobs,
+		},
+		&cli.StringFlag{
+			Name:        "output",
+			Aliases:     []string{"o"},
+			Usage:       "Print results as text, json or ndjson",
+			Value:       "text",
+			Destination: &workers.Output,
+		},
+		&cli.DurationFlag{
+			Name:        "timeout",
+			Usage:       "Stop starting new repos and abort running ones after this long, e.g. 10m",
+			Destination: &workers.Timeout,
+		},
+		&cli.DurationFlag{
+			Name:        "command-timeout",
+			Usage:       "Kill any single git command that runs longer than this (default 10m)",
+			Destination: &workers.CommandTimeout,
+		},
+	}
+
+	// Narrow the repos a command touches
+	selectors := []cli.Flag{
+		&cli.StringSliceFlag{
+			Name:    "group",
+			Aliases: []string{"g"},
+			Usage:   "Only repos in these manifest groups, as with `repo sync -g`; prefix a group with - to exclude it",
+		},
+		&cli.StringSliceFlag{
+			Name:  "project",
+			Usage: "Only these manifest projects, by name",
+		},
+		&cli.StringSliceFlag{
+			Name:  "path",
+			Usage: "Only the projects at or below these paths in the workspace",
+		},
+	}
+
+	// For the commands that fetch remotes before comparing against them
+	fetching := []cli.Flag{
+		&cli.BoolFlag{
+			Name:        "offline",
+			Aliases:     []string{"no-fetch"},
+			Usage:       "Use the remote-tracking refs already fetched instead of updating remotes; results say how old they are",
+			Destination: &workers.Offline,
+		},
+		&cli.DurationFlag{
+			Name:        "fetch-ttl",
+			Usage:       "Skip fetching repos fetched less than this long ago, e.g. 10m (default from config, else always fetch)",
+			Destination: &workers.FetchTTL,
+		},
 	}
 
 	commands := []*cli.Command{
@@ -35,31 +132,210 @@ func (ycli *yCLI) run() {
 			Action:      entryPoint,
 			Flags:       flags,
 			UsageText:   "yeet refresh",
-			Description: "Generates a list of repos across which to perform the target rebase and saves the results to repolist.json. Uses the `repo list` command.",
+			Description: "Generates a list of repos across which to perform the target rebase and saves the results to .yeet/repolist.json in the repo directory. Reads .repo/manifest.xml, its includes and any local manifests directly, so the repo tool itself is not needed.",
+		},
+		{
+			Name:   "take",
+			Usage:  "Checkout and rebase target branch onto the tip of main across all repos",
+			Action: entryPoint,
+			Flags: withFlags(withFlags(withFlags(flags, selectors...), fetching...),
+				&cli.BoolFlag{
+					Name:        "restore-stash",
+					Usage:       "Re-apply the yeet stash made on the branch each repo ends up on",
+					Destination: &restoreStash,
+				},
+				&cli.BoolFlag{
+					Name:        "dry-run",
+					Usage:       "Fetch and print the checkouts and rebases take would do without changing anything",
+					Destination: &dryRun,
+				},
+				&cli.StringFlag{
+					Name:        "strategy",
+					Usage:       "How to bring topics onto the base branch: rebase, merge, ff-only or cherry-pick (default from config, else rebase)",
+					Destination: &strategy,
+				},
+				&cli.BoolFlag{
+					Name:        "keep-conflicts",
+					Usage:       "Leave repos with conflicts mid-rebase, to be finished with `yeet continue` or `yeet abort`",
+					Destination: &keepConflicts,
+				},
+				&cli.StringFlag{
+					Name:        "gerrit-topic",
+					Usage:       "Take the open Gerrit changes in this topic instead of a branch",
+					Destination: &gerritTopic,
+				},
+				&cli.StringSliceFlag{
+					Name:  "change",
+					Usage: "Take this Gerrit change, as <number> or <number>/<patchset>; can be repeated",
+				},
+			),
+			UsageText:   "yeet take [--dry-run] [--offline | --fetch-ttl <duration>] [--strategy <strategy>] [--keep-conflicts] [--group <group>] [--project <name>] [--path <path>] <targetbranch> [<targetbranch>...]\n   yeet take [--dry-run] --gerrit-topic <topic> | --change <number>[/<patchset>]",
+			Description: "Rebases origin/<targetbranch> onto the tip of origin/main across all repos. All repositories that do not have the branch origin/<targetbranch> are updated to the tip of origin/main. When several target branches are given, each repo takes the first one it has, locally or on the remote. With --gerrit-topic or --change the changes are looked up on the Gerrit server set in the config, fetched from refs/changes and rebased onto the base branch of their project, on a branch named after the topic or change. With --offline nothing is fetched and the remote-tracking refs from the last fetch are used, and with --fetch-ttl repos fetched recently are not fetched again. With --strategy merge the topic is merged into the base branch on a detached HEAD so its commits keep their hashes and the topic branch is left unchanged, and with --strategy ff-only a repo fails rather than have its history rewritten. repolist.json must exist.",
 		},
 		{
-			Name:        "take",
-			Usage:       "Checkout and rebase target branch onto the tip of main across all repos",
+			Name:   "sync",
+			Usage:  "Bring every repo to the tip of its base branch",
+			Action: entryPoint,
+			Flags: withFlags(withFlags(withFlags(flags, selectors...), fetching...),
+				&cli.BoolFlag{
+					Name:        "force",
+					Aliases:     []string{"f"},
+					Usage:       "Sync repos with unpushed commits too, and stash uncommitted changes instead of skipping the repo",
+					Destination: &forceSync,
+				},
+			),
+			UsageText:   "yeet sync [--force] [--offline | --fetch-ttl <duration>] [--group <group>] [--project <name>] [--path <path>]",
+			Description: "Fetches, checks out the base branch in every repo and brings it up to the remote, as take does for repos without the topic. Repos with uncommitted changes, unpushed commits on the current or base branch, or a rebase, merge or cherry-pick in progress are skipped; with --force they are synced anyway and uncommitted changes are stashed first, labelled `yeet: <branch> before sync`.",
+		},
+		{
+			Name:   "start",
+			Usage:  "Create a topic branch from the base branch in the chosen repos",
+			Action: entryPoint,
+			Flags: withFlags(withFlags(withFlags(flags, selectors...), fetching...),
+				&cli.BoolFlag{
+					Name:        "changed",
+					Usage:       "Only the repos with uncommitted changes, which are carried over to the new branch",
+					Destination: &onlyChanged,
+				},
+			),
+			UsageText:   "yeet start [--changed] [--group <group>] [--project <name>] [--path <path>] <branch>",
+			Description: "Fetches and creates <branch> from the tip of the base branch on the remote, tracking it, in the repos chosen with --group, --project and --path, or in every repo with uncommitted changes with --changed. Uncommitted changes stay in the working tree and end up on the new branch. Repos that already have the branch are left alone.",
+		},
+		{
+			Name:   "push",
+			Usage:  "Push a topic branch from every repo that has it",
+			Action: entryPoint,
+			Flags: withFlags(withFlags(flags, selectors...),
+				&cli.BoolFlag{
+					Name:        "set-upstream",
+					Aliases:     []string{"u"},
+					Usage:       "Make the pushed remote branch the upstream of the local one",
+					Destination: &setUpstream,
+				},
+			),
+			UsageText:   "yeet push [--set-upstream] [--group <group>] [--project <name>] [--path <path>] [<branch>]",
+			Description: "Pushes <branch>, or the branch each repo is on if none is given, from every repo where it exists locally to the same name on the remote. The base branch is never pushed, whether named or checked out. Since take rewrites branches the push is forced, but with --force-with-lease against the remote commit seen at the last fetch, so a push fails rather than overwrite commits someone else pushed since. Nothing is fetched first.",
+		},
+		{
+			Name:        "undo",
+			Usage:       "Return all repos to their state before the last take",
+			Action:      entryPoint,
+			Flags:       flags,
+			UsageText:   "yeet undo",
+			Description: "Checks out the original branch at the original commit in every repo touched by the last take, restores any branches the take rewrote and re-applies the changes it stashed. The state is read from the journal written by `yeet take`.",
+		},
+		{
+			Name:        "continue",
+			Usage:       "Finish a take in the repos whose conflicts have been resolved",
 			Action:      entryPoint,
 			Flags:       flags,
-			UsageText:   "yeet take <targetbranch>",
-			Description: "Rebases origin/<targetbranch> onto the tip of origin/main across all repos. All repositories that do not have the branch origin/<targetbranch> are updated to the tip of origin/main. repolist.json must exist.",
+			UsageText:   "yeet continue",
+			Description: "For every repo left with a conflict by `yeet take --keep-conflicts`, continues the rebase, merge or cherry-pick once all conflicts are marked resolved with `git add`, then finishes the rest of the take. Repos that stop on another conflict are left open again.",
 		},
 		{
-			Name:        "find",
-			Usage:       "Searches all repositories for the chosen branch",
+			Name:        "abort",
+			Usage:       "Abort the conflicts left open by a take",
 			Action:      entryPoint,
 			Flags:       flags,
-			UsageText:   "yeet find <targetbranch>",
-			Description: "Searches all repos on their local and remotes for the target branch. Only print repos where something is found.",
+			UsageText:   "yeet abort",
+			Description: "Aborts the rebase, merge or cherry-pick in every repo left with a conflict by `yeet take --keep-conflicts`, leaving the repo as a take without the flag would have. Use `yeet undo` to roll back the whole take.",
+		},
+		{
+			Name:   "exec",
+			Usage:  "Run a command in every repo in parallel",
+			Action: entryPoint,
+			Flags: withFlags(withFlags(flags, selectors...),
+				&cli.BoolFlag{
+					Name:        "group-output",
+					Usage:       "Print each repo's output in one block once its command finishes, instead of line by line",
+					Destination: &groupOutput,
+				},
+			),
+			UsageText:   "yeet exec [--group-output] [--group <group>] [--project <name>] [--path <path>] -- <command> [<args>...]",
+			Description: "Runs the command in every repo, like `repo forall`, with REPO_PATH, REPO_PROJECT and REPO_REMOTE set. A command given as a single argument is run through the shell, so `yeet exec -- 'git log -1 | cat'` works. Each line of output is prefixed with the repo name, or with --group-output printed together once the repo is done. Repos where the command fails are listed at the end with their exit codes. With -o json the output of each repo is captured in its record.",
+		},
+		{
+			Name:        "stash",
+			Usage:       "Manage the stashes yeet has made across all repos",
+			UsageText:   "yeet stash list|pop|drop",
+			Description: "Every stash yeet makes is labelled `yeet: <branch> before <action>`. These commands only touch those stashes.",
+			Subcommands: []*cli.Command{
+				{
+					Name:        "list",
+					Usage:       "List the yeet stashes in every repo",
+					Action:      entryPoint,
+					Flags:       flags,
+					UsageText:   "yeet stash list",
+					Description: "Lists every stash made by yeet in every repo.",
+				},
+				{
+					Name:        "pop",
+					Usage:       "Pop the newest yeet stash made on the current branch of every repo",
+					Action:      entryPoint,
+					Flags:       flags,
+					UsageText:   "yeet stash pop",
+					Description: "In every repo, pops the newest stash that yeet made on the branch the repo is currently on.",
+				},
+				{
+					Name:   "drop",
+					Usage:  "Drop the newest yeet stash made on the current branch of every repo",
+					Action: entryPoint,
+					Flags: withFlags(flags,
+						&cli.BoolFlag{
+							Name:        "all",
+							Usage:       "Drop every yeet stash, whichever branch it was made on",
+							Destination: &allStashes,
+						},
+					),
+					UsageText:   "yeet stash drop [--all]",
+					Description: "In every repo, drops the newest stash that yeet made on the branch the repo is currently on.",
+				},
+			},
+		},
+		{
+			Name:        "fetch",
+			Usage:       "Fetch every remote of all repos",
+			Action:      entryPoint,
+			Flags:       withFlags(flags, selectors...),
+			UsageText:   "yeet fetch [--group <group>] [--project <name>] [--path <path>]",
+			Description: "Runs `git remote update` in every repo and records when, in .yeet/fetch.json in the workspace. status, find and take then skip fetching repos fetched less than fetchttl (or --fetch-ttl) ago and use the remote-tracking refs as they are.",
+		},
+		{
+			Name:   "find",
+			Usage:  "Searches all repositories for branches matching a pattern",
+			Action: entryPoint,
+			Flags: withFlags(withFlags(withFlags(flags, selectors...), fetching...),
+				&cli.BoolFlag{
+					Name:        "regex",
+					Usage:       "Treat the pattern as a regular expression instead of a glob",
+					Destination: &findRegex,
+				},
+			),
+			UsageText:   "yeet find [--regex] [--offline | --fetch-ttl <duration>] [--group <group>] [--project <name>] [--path <path>] <pattern>",
+			Description: "Lists every local and remote branch whose name matches the pattern, with its tip commit, author, commit date and how far it is ahead of and behind the base branch. The pattern is a glob matched against the whole branch name, without the remote, where * also matches /, so 'feature/*login*' finds feature/fix-login; with --regex it is a regular expression that may match any part of the name. Only repos where something is found are printed. With --offline, or for repos fetched within --fetch-ttl, the remotes are not fetched first.",
+		},
+		{
+			Name:   "topics",
+			Usage:  "List the branches found in several repos",
+			Action: entryPoint,
+			Flags: withFlags(withFlags(withFlags(flags, selectors...), fetching...),
+				&cli.IntFlag{
+					Name:        "min-repos",
+					Usage:       "Only list branches found in at least this many repos",
+					Value:       2,
+					Destination: &minRepos,
+				},
+			),
+			UsageText:   "yeet topics [--min-repos <n>] [--offline | --fetch-ttl <duration>] [--group <group>] [--project <name>] [--path <path>]",
+			Description: "Fetches every repo once, then lists each remote branch found in two or more repos, newest first, with the repos that have it, the date of the newest commit at its tips and their authors. The base branch is left out. Use it to find cross-repo features to take without knowing their exact names.",
 		},
 		{
 			Name:        "status",
 			Usage:       "Check the status of all repos",
 			Action:      entryPoint,
-			Flags:       flags,
-			UsageText:   "yeet status",
-			Description: "Checks the status of the current branch of every repo by checking the local and remote commit hashes.",
+			Flags:       withFlags(withFlags(flags, selectors...), fetching...),
+			UsageText:   "yeet status [--offline | --fetch-ttl <duration>] [--group <group>] [--project <name>] [--path <path>]",
+			Description: "Checks the status of the current branch of every repo by checking the local and remote commit hashes. With --offline, or for repos fetched within --fetch-ttl, the remotes are not fetched first and each result says how old the last fetch is.",
 		},
 	}
 
@@ -70,11 +346,21 @@ func (ycli *yCLI) run() {
 		Commands: commands,
 	}
 
+	// Errors carrying an exit code are printed and exited on by the cli package,
+	// anything left is a problem with the command line itself
 	if err := app.Run(ycli.args); err != nil {
-		log.Fatal(err)
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(workers.ExitSetup)
 	}
 }
 
+// Copies the shared flags so each command can append its own
+func withFlags(shared []cli.Flag, extra ...cli.Flag) []cli.Flag {
+	all := make([]cli.Flag, 0, len(shared)+len(extra))
+	all = append(all, shared...)
+	return append(all, extra...)
+}
+
 func entryPoint(cCtx *cli.Context) error {
 	if debugMode {
 		fmt.Println("Running with debug ENABLED")
@@ -89,6 +375,24 @@ func entryPoint(cCtx *cli.Context) error {
 		return findAction(cCtx)
 	case "status":
 		return statusAction(cCtx)
+	case "topics":
+		return topicsAction(cCtx)
+	case "fetch":
+		return fetchAction(cCtx)
+	case "sync":
+		return syncAction(cCtx)
+	case "start":
+		return startAction(cCtx)
+	case "push":
+		return pushAction(cCtx)
+	case "exec":
+		return execAction(cCtx)
+	case "undo":
+		return undoAction(cCtx)
+	case "continue", "abort":
+		return conflictAction(cCtx)
+	case "stash list", "stash pop", "stash drop":
+		return stashAction(cCtx)
 	default:
 		cli.ShowAppHelp(cCtx)
 	}
@@ -98,37 +402,184 @@ func entryPoint(cCtx *cli.Context) error {
 
 func refreshAction(cCtx *cli.Context) error {
 	if cCtx.NArg() > 0 {
-		return fmt.Errorf("refresh takes no arguments")
+		return usageErrorf("refresh takes no arguments")
 	}
-	workers.RefreshCmd()
-	return nil
+	return workers.RefreshCmd()
 }
 
 func takeAction(cCtx *cli.Context) error {
-	if cCtx.NArg() != 1 {
-		return fmt.Errorf("take needs 1 argument, got %d", cCtx.NArg())
+	changes := cCtx.StringSlice("change")
+	if gerritTopic != "" || len(changes) > 0 {
+		if cCtx.NArg() > 0 {
+			return usageErrorf("take does not take branch names with --gerrit-topic or --change")
+		}
+	} else if cCtx.NArg() < 1 {
+		return usageErrorf("take needs at least 1 argument, got %d", cCtx.NArg())
 	}
-	branchName := cCtx.Args().Get(0)
-	workers.SetupCmd()
-	workers.TakeCmd(branchName)
-	return nil
+	branchNames := cCtx.Args().Slice()
+	selectRepos(cCtx)
+	if err := workers.SetupCmd(); err != nil {
+		return err
+	}
+	defer workers.FinishCmd()
+	return workers.TakeCmd(branchNames, &workers.TakeOptions{
+		RestoreStash:  restoreStash,
+		DryRun:        dryRun,
+		Strategy:      strategy,
+		KeepConflicts: keepConflicts,
+		GerritTopic:   gerritTopic,
+		Changes:       changes,
+	})
 }
 
 func findAction(cCtx *cli.Context) error {
 	if cCtx.NArg() != 1 {
-		return fmt.Errorf("take needs 1 argument, got %d", cCtx.NArg())
+		return usageErrorf("find needs 1 argument, got %d", cCtx.NArg())
 	}
 	branchName := cCtx.Args().Get(0)
-	workers.SetupCmd()
-	workers.FindCmd(branchName)
-	return nil
+	selectRepos(cCtx)
+	if err := workers.SetupCmd(); err != nil {
+		return err
+	}
+	defer workers.FinishCmd()
+	return workers.FindCmd(branchName, findRegex)
 }
 
 func statusAction(cCtx *cli.Context) error {
 	if cCtx.NArg() > 0 {
-		return fmt.Errorf("take needs no arguments")
+		return usageErrorf("status takes no arguments")
+	}
+	selectRepos(cCtx)
+	if err := workers.SetupCmd(); err != nil {
+		return err
+	}
+	defer workers.FinishCmd()
+	return workers.StatusCmd()
+}
+
+func topicsAction(cCtx *cli.Context) error {
+	if cCtx.NArg() > 0 {
+		return usageErrorf("topics takes no arguments")
+	}
+	selectRepos(cCtx)
+	if err := workers.SetupCmd(); err != nil {
+		return err
+	}
+	defer workers.FinishCmd()
+	return workers.TopicsCmd(minRepos)
+}
+
+func fetchAction(cCtx *cli.Context) error {
+	if cCtx.NArg() > 0 {
+		return usageErrorf("fetch takes no arguments")
+	}
+	selectRepos(cCtx)
+	if err := workers.SetupCmd(); err != nil {
+		return err
+	}
+	defer workers.FinishCmd()
+	return workers.FetchCmd()
+}
+
+func syncAction(cCtx *cli.Context) error {
+	if cCtx.NArg() > 0 {
+		return usageErrorf("sync takes no arguments")
+	}
+	selectRepos(cCtx)
+	if err := workers.SetupCmd(); err != nil {
+		return err
+	}
+	defer workers.FinishCmd()
+	return workers.SyncCmd(forceSync)
+}
+
+func startAction(cCtx *cli.Context) error {
+	if cCtx.NArg() != 1 {
+		return usageErrorf("start needs 1 argument, got %d", cCtx.NArg())
+	}
+	branchName := cCtx.Args().Get(0)
+	selectRepos(cCtx)
+	if err := workers.SetupCmd(); err != nil {
+		return err
+	}
+	defer workers.FinishCmd()
+	return workers.StartCmd(branchName, onlyChanged)
+}
+
+func pushAction(cCtx *cli.Context) error {
+	if cCtx.NArg() > 1 {
+		return usageErrorf("push takes at most 1 argument, got %d", cCtx.NArg())
+	}
+	branchName := cCtx.Args().Get(0)
+	selectRepos(cCtx)
+	if err := workers.SetupCmd(); err != nil {
+		return err
+	}
+	defer workers.FinishCmd()
+	return workers.PushCmd(branchName, setUpstream)
+}
+
+func execAction(cCtx *cli.Context) error {
+	if cCtx.NArg() < 1 {
+		return usageErrorf("exec needs a command to run after --")
+	}
+	selectRepos(cCtx)
+	if err := workers.SetupCmd(); err != nil {
+		return err
+	}
+	defer workers.FinishCmd()
+	return workers.ExecCmd(cCtx.Args().Slice(), groupOutput)
+}
+
+func undoAction(cCtx *cli.Context) error {
+	if cCtx.NArg() > 0 {
+		return usageErrorf("undo takes no arguments")
+	}
+	if err := workers.SetupCmd(); err != nil {
+		return err
+	}
+	defer workers.FinishCmd()
+	return workers.UndoCmd()
+}
+
+func conflictAction(cCtx *cli.Context) error {
+	if cCtx.NArg() > 0 {
+		return usageErrorf("%s takes no arguments", cCtx.Command.Name)
+	}
+	if err := workers.SetupCmd(); err != nil {
+		return err
+	}
+	defer workers.FinishCmd()
+	if cCtx.Command.Name == "continue" {
+		return workers.ContinueCmd()
+	}
+	return workers.AbortCmd()
+}
+
+func stashAction(cCtx *cli.Context) error {
+	if cCtx.NArg() > 0 {
+		return usageErrorf("stash %s takes no arguments", cCtx.Command.Name)
+	}
+	if err := workers.SetupCmd(); err != nil {
+		return err
+	}
+	defer workers.FinishCmd()
+	return workers.StashCmd(cCtx.Command.Name, allStashes)
+}
+
+func usageErrorf(format string, a ...interface{}) error {
+	return &workers.SetupError{Err: fmt.Errorf(format, a...)}
+}
+
+// Groups may also be given as one comma separated list, as with `repo sync -g`
+func selectRepos(cCtx *cli.Context) {
+	groups := make([]string, 0)
+	for _, g := range cCtx.StringSlice("group") {
+		groups = append(groups, strings.Split(g, ",")...)
+	}
+	workers.Filter = workers.RepoFilter{
+		Groups:   groups,
+		Projects: cCtx.StringSlice("project"),
+		Paths:    cCtx.StringSlice("path"),
 	}
-	workers.SetupCmd()
-	workers.StatusCmd()
-	return nil
 }
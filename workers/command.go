@@ -2,10 +2,18 @@ package workers
 
 import (
 	"bufio"
+	"context"
 	"os/exec"
 	"strings"
+	"time"
 )
 
+// Cancelled on SIGINT or when the overall timeout runs out
+var runContext context.Context = context.Background()
+
+// The longest a single git command may run, zero for no limit
+var CommandTimeout time.Duration = 0
+
 type GitCommand struct {
 	Args []string
 	Path string
@@ -18,16 +26,36 @@ type GitCommandResult struct {
 }
 
 func (gcmd *GitCommand) Run() *GitCommandResult {
+	return gcmd.RunContext(runContext)
+}
+
+// Runs the command, killing it if ctx is done or CommandTimeout runs out
+func (gcmd *GitCommand) RunContext(ctx context.Context) *GitCommandResult {
+	if CommandTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, CommandTimeout)
+		defer cancel()
+	}
 	cmd := exec.Command("git", gcmd.Args...)
 	cmd.Dir = gcmd.Path
+	setProcessGroup(cmd)
 	stdout, err := cmd.StdoutPipe()
-	rd := bufio.NewReader(stdout)
 	if err != nil {
-		panic(err)
+		return &GitCommandResult{nil, false, 1}
 	}
+	rd := bufio.NewReader(stdout)
 	if err := cmd.Start(); err != nil {
 		return &GitCommandResult{nil, false, 1}
 	}
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-ctx.Done():
+			killProcessGroup(cmd)
+		case <-finished:
+		}
+	}()
 	lines := make([]string, 0)
 	// Make a custom decoder for each of these
 	b, err := rd.ReadString('\n')
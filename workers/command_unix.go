@@ -0,0 +1,25 @@
+//go:build !windows
+
+package workers
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// Runs git in its own process group, so a Ctrl-C in the terminal reaches yeet
+// only and yeet decides what to stop and clean up
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// Kills git along with any children it started, such as the fetches run by
+// `git remote update`
+func killProcessGroup(cmd *exec.Cmd) {
+	_ = syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
+
+// Runs a single command line given to yeet exec through the shell
+func shellArgs(script string) []string {
+	return []string{"sh", "-c", script}
+}
@@ -0,0 +1,17 @@
+//go:build windows
+
+package workers
+
+import (
+	"os/exec"
+)
+
+func setProcessGroup(cmd *exec.Cmd) {}
+
+func killProcessGroup(cmd *exec.Cmd) {
+	_ = cmd.Process.Kill()
+}
+
+func shellArgs(script string) []string {
+	return []string{"cmd", "/C", script}
+}
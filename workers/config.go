@@ -0,0 +1,124 @@
+package workers
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Set via the --config flag
+var ConfigPath string = ""
+
+var ConfigEnv string = "YEET_CONFIG"
+
+// Read from the root of the repo workspace, next to the .repo directory
+var WorkspaceConfigFilename string = ".yeet.yaml"
+
+// Looks for the config file in order of precedence: the --config flag, the
+// YEET_CONFIG environment variable, .yeet.yaml in the workspace root,
+// $XDG_CONFIG_HOME/yeet/config.yaml, then config.yaml next to the executable.
+// Returns an empty path if there is no config file anywhere.
+func FindConfig() (string, error) {
+	if ConfigPath != "" {
+		if _, err := os.Stat(ConfigPath); err != nil {
+			return "", fmt.Errorf("config file given by --config: %s", err)
+		}
+		return ConfigPath, nil
+	}
+	if envPath := os.Getenv(ConfigEnv); envPath != "" {
+		if _, err := os.Stat(envPath); err != nil {
+			return "", fmt.Errorf("config file given by %s: %s", ConfigEnv, err)
+		}
+		return envPath, nil
+	}
+	candidates := make([]string, 0)
+	if cwd, err := os.Getwd(); err == nil {
+		if root, ok := findWorkspaceRoot(cwd); ok {
+			candidates = append(candidates, filepath.Join(root, WorkspaceConfigFilename))
+		}
+	}
+	if xdg := os.Getenv("XDG_CONFIG_HOME"); xdg != "" {
+		candidates = append(candidates, filepath.Join(xdg, "yeet", "config.yaml"))
+	} else if home, err := os.UserHomeDir(); err == nil {
+		candidates = append(candidates, filepath.Join(home, ".config", "yeet", "config.yaml"))
+	}
+	if ex, err := os.Executable(); err == nil {
+		candidates = append(candidates, filepath.Join(filepath.Dir(ex), "config.yaml"))
+	}
+	for _, candidate := range candidates {
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate, nil
+		}
+	}
+	return "", nil
+}
+
+// Walks up from dir to the directory holding the repo tool's .repo directory
+func findWorkspaceRoot(dir string) (string, bool) {
+	for {
+		if fi, err := os.Stat(filepath.Join(dir, ".repo")); err == nil && fi.IsDir() {
+			return dir, true
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", false
+		}
+		dir = parent
+	}
+}
+
+// Reads the config file at path, if any, then applies YEET_* environment
+// variable overrides and checks the required values are set
+func LoadConfig(path string) (*ProgramConfig, error) {
+	var tempconfig ProgramConfig
+	if path != "" {
+		yamlFile, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := yaml.Unmarshal(yamlFile, &tempconfig); err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+	}
+	if err := applyEnvOverrides(&tempconfig); err != nil {
+		return nil, err
+	}
+	if tempconfig.FCRemote == "" || tempconfig.RepoDir == "" || tempconfig.MasterBranch == "" {
+		if path == "" {
+			return nil, fmt.Errorf("no config file found and fcr, repodir and masterbranch are not all set by YEET_* variables")
+		}
+		return nil, fmt.Errorf("%s is missing values for fcr, repodir or masterbranch", path)
+	}
+	return &tempconfig, nil
+}
+
+// Each field can be overridden by YEET_ followed by its upper-cased yaml key,
+// e.g. YEET_MASTERBRANCH. Values are parsed as YAML so durations and numbers work.
+func applyEnvOverrides(c *ProgramConfig) error {
+	v := reflect.ValueOf(c).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		key := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+		if key == "" || key == "-" {
+			continue
+		}
+		name := "YEET_" + strings.ToUpper(key)
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		if v.Field(i).Kind() == reflect.String {
+			v.Field(i).SetString(value)
+			continue
+		}
+		if err := yaml.Unmarshal([]byte(value), v.Field(i).Addr().Interface()); err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+	}
+	return nil
+}
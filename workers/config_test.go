@@ -0,0 +1,83 @@
+package workers_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	workers "yeet/workers"
+)
+
+func writeConfig(t *testing.T, path string, masterBranch string) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	text := "masterbranch: " + masterBranch + "\nfcr: origin\nrepodir: /src\n"
+	if err := ioutil.WriteFile(path, []byte(text), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFindConfigOrder(t *testing.T) {
+	dir := t.TempDir()
+	workspace := filepath.Join(dir, "workspace")
+	if err := os.MkdirAll(filepath.Join(workspace, ".repo"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	subdir := filepath.Join(workspace, "some", "project")
+	if err := os.MkdirAll(subdir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	cwd, _ := os.Getwd()
+	defer os.Chdir(cwd)
+	if err := os.Chdir(subdir); err != nil {
+		t.Fatal(err)
+	}
+
+	xdgPath := filepath.Join(dir, "xdg", "yeet", "config.yaml")
+	workspacePath := filepath.Join(workspace, ".yeet.yaml")
+	envPath := filepath.Join(dir, "env.yaml")
+	flagPath := filepath.Join(dir, "flag.yaml")
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(dir, "xdg"))
+	t.Setenv("YEET_CONFIG", "")
+	defer func() { workers.ConfigPath = "" }()
+
+	steps := []struct {
+		setup func()
+		want  string
+	}{
+		{func() { writeConfig(t, xdgPath, "xdg") }, xdgPath},
+		{func() { writeConfig(t, workspacePath, "workspace") }, workspacePath},
+		{func() { writeConfig(t, envPath, "env"); t.Setenv("YEET_CONFIG", envPath) }, envPath},
+		{func() { writeConfig(t, flagPath, "flag"); workers.ConfigPath = flagPath }, flagPath},
+	}
+	for _, step := range steps {
+		step.setup()
+		got, err := workers.FindConfig()
+		if err != nil {
+			t.Fatalf(`Error finding config: %v`, err)
+		}
+		if got != step.want {
+			t.Fatalf(`Found config %s, expected %s`, got, step.want)
+		}
+	}
+}
+
+func TestLoadConfigEnvOverrides(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig(t, path, "main")
+	t.Setenv("YEET_MASTERBRANCH", "develop")
+	t.Setenv("YEET_JOBS", "3")
+	t.Setenv("YEET_COMMANDTIMEOUT", "90s")
+	config, err := workers.LoadConfig(path)
+	if err != nil {
+		t.Fatalf(`Error loading config: %v`, err)
+	}
+	if config.MasterBranch != "develop" || config.FCRemote != "origin" {
+		t.Fatalf(`String override not applied: %+v`, config)
+	}
+	if config.Jobs != 3 || config.CommandTimeout != 90*time.Second {
+		t.Fatalf(`Typed overrides not applied: %+v`, config)
+	}
+}
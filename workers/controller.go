@@ -1,36 +1,112 @@
 package workers
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
-	"io/ioutil"
-	"log"
 	"os"
+	"os/signal"
 	"path/filepath"
-	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/TwiN/go-color"
-	"gopkg.in/yaml.v3"
+	"golang.org/x/exp/slices"
 )
 
 type ProgramConfig struct {
 	MasterBranch string `yaml:"masterbranch"`
-	FCRemote     string `yaml:"fcr"`
-	RepoDir      string `yaml:"repodir"`
+	// Per-repo base branches, keyed by project name or path
+	BaseBranches map[string]string `yaml:"basebranches"`
+	FCRemote     string            `yaml:"fcr"`
+	RepoDir      string            `yaml:"repodir"`
+	RestoreStash bool              `yaml:"restorestash"`
+	// rebase, merge, ff-only or cherry-pick
+	Strategy string `yaml:"strategy"`
+	Jobs     int    `yaml:"jobs"`
+	// Durations such as 30s or 10m
+	Timeout        time.Duration `yaml:"timeout"`
+	CommandTimeout time.Duration `yaml:"commandtimeout"`
+	// How long a fetch stays fresh enough to skip the next one
+	FetchTTL time.Duration `yaml:"fetchttl"`
+	// Gerrit server for take --gerrit-topic and --change, e.g. https://review.example.com
+	GerritURL      string `yaml:"gerrit"`
+	GerritUser     string `yaml:"gerrituser"`
+	GerritPassword string `yaml:"gerritpassword"`
+}
+
+type TakeOptions struct {
+	// Re-apply the yeet stash made on the branch a repo ends up on
+	RestoreStash bool
+	// Only print what would be done to each repo
+	DryRun bool
+	// How topics are brought onto their base branch, one of Strategies
+	Strategy string
+	// Leave conflicted repos mid-rebase for yeet continue or yeet abort
+	KeepConflicts bool
+	// Take open Gerrit changes instead of branches
+	GerritTopic string
+	// Gerrit changes given as <number> or <number>/<patchset>
+	Changes []string
+	// The resolved changes, keyed by project name
+	gerritChanges map[string][]*GerritChange
 }
 
 type WorkFlowResult struct {
-	RepoName string
-	Status   Status
-	Message  string
+	RepoName  string `json:"name"`
+	Path      string `json:"path"`
+	Status    Status `json:"-"`
+	OldBranch string `json:"old_branch"`
+	NewBranch string `json:"new_branch"`
+	OldSHA    string `json:"old_sha"`
+	NewSHA    string `json:"new_sha"`
+	Error     string `json:"error,omitempty"`
+	// Set when the status is CNFLCT because a rebase, merge or cherry-pick stopped
+	Conflict *ConflictInfo `json:"conflict,omitempty"`
+	// Only set by status
+	State *RepoState `json:"state,omitempty"`
+	// Set when the remote was not fetched, because of --offline or because it
+	// was fetched within the fetch TTL, with when it was fetched if known
+	Cached    bool       `json:"cached,omitempty"`
+	Offline   bool       `json:"offline,omitempty"`
+	FetchedAt *time.Time `json:"fetched_at,omitempty"`
+	// Human readable summary, only printed in text output
+	Message string `json:"-"`
+}
+
+// The commit that failed to apply, and where, recorded before the abort
+type ConflictInfo struct {
+	Commit      string   `json:"commit"`
+	Subject     string   `json:"subject"`
+	Author      string   `json:"author"`
+	AuthorEmail string   `json:"author_email"`
+	Onto        string   `json:"onto"`
+	OntoSHA     string   `json:"onto_sha"`
+	Paths       []string `json:"paths"`
 }
 
+// A branch found by yeet find, or the error searching a repo
 type SearchResult struct {
-	RepoName string
-	Target   string
+	RepoName string `json:"name"`
+	Path     string `json:"path"`
+	// The branch, prefixed with the remote for remote-tracking branches
+	Target string    `json:"target"`
+	Remote bool      `json:"remote"`
+	SHA    string    `json:"sha,omitempty"`
+	Author string    `json:"author,omitempty"`
+	Date   time.Time `json:"date"`
+	// Commits on the branch that are not on the base branch, and the reverse
+	Base   string `json:"base,omitempty"`
+	Ahead  int    `json:"ahead"`
+	Behind int    `json:"behind"`
+	Error  string `json:"error,omitempty"`
+	// Set when the remote was not fetched, see WorkFlowResult
+	Cached    bool       `json:"cached,omitempty"`
+	Offline   bool       `json:"offline,omitempty"`
+	FetchedAt *time.Time `json:"fetched_at,omitempty"`
 }
 
 type Status struct {
@@ -39,8 +115,9 @@ type Status struct {
 	Code  int
 }
 
+// Padded to six characters so the result lines stay aligned
 func (s *Status) ToString() string {
-	return s.color + s.text + color.Reset
+	return fmt.Sprintf("%s%-6s%s", s.color, s.text, color.Reset)
 }
 
 func (r *WorkFlowResult) Format() string {
@@ -50,11 +127,73 @@ func (r *WorkFlowResult) Format() string {
 		filler.WriteString(".")
 	}
 
-	return fmt.Sprintf(" %s %s%s%s\n", r.Status.ToString(), r.Message, filler.String(), r.RepoName)
+	line := fmt.Sprintf(" %s %s%s%s\n", r.Status.ToString(), r.Message, filler.String(), r.RepoName)
+	if r.Conflict != nil {
+		line += r.Conflict.Format()
+	}
+	return line
+}
+
+// Indented under the result line of the repo
+func (c *ConflictInfo) Format() string {
+	var text strings.Builder
+	if c.Commit != "" {
+		fmt.Fprintf(&text, "        %s %s by %s <%s>\n", shortSHA(c.Commit), c.Subject, c.Author, c.AuthorEmail)
+	}
+	switch {
+	case c.Onto == "":
+		// Not known when a continued operation stops again
+	case shaPattern.MatchString(c.Onto):
+		fmt.Fprintf(&text, "        onto %s\n", shortSHA(c.Onto))
+	default:
+		fmt.Fprintf(&text, "        onto %s [%s]\n", c.Onto, shortSHA(c.OntoSHA))
+	}
+	if len(c.Paths) > 0 {
+		fmt.Fprintf(&text, "        conflicts in %s\n", strings.Join(c.Paths, ", "))
+	}
+	return text.String()
+}
+
+func shortSHA(sha string) string {
+	if len(sha) > 8 {
+		return sha[:8]
+	}
+	return sha
+}
+
+// Adds the status text and code, which are kept unexported in Status
+func (r *WorkFlowResult) MarshalJSON() ([]byte, error) {
+	type result WorkFlowResult
+	return json.Marshal(&struct {
+		*result
+		Status string `json:"status"`
+		Code   int    `json:"code"`
+	}{(*result)(r), r.Status.text, r.Status.Code})
 }
 
 func (s *SearchResult) ToString() string {
-	return color.Yellow + s.RepoName + color.Reset + ": " + color.Green + s.Target + color.Reset
+	if s.Error != "" {
+		return color.Yellow + s.RepoName + color.Reset + ": " + color.Red + s.Error + color.Reset
+	}
+	text := color.Yellow + s.RepoName + color.Reset + ": " + color.Green + s.Target + color.Reset
+	if s.SHA != "" {
+		text += fmt.Sprintf(" [%s] %s %s", shortSHA(s.SHA), s.Date.Format("2006-01-02"), s.Author)
+	}
+	if s.Base != "" {
+		text += fmt.Sprintf(", +%d/-%d %s", s.Ahead, s.Behind, s.Base)
+	}
+	if s.Cached {
+		age := time.Time{}
+		if s.FetchedAt != nil {
+			age = *s.FetchedAt
+		}
+		text += " (" + fetchAge(s.Offline, age) + ")"
+	}
+	return text
+}
+
+func (s *SearchResult) Format() string {
+	return s.ToString() + "\n"
 }
 
 var PASSED Status = Status{"PASSED", color.Green, 0}
@@ -62,118 +201,506 @@ var FAILED Status = Status{"FAILED", color.Red, 1}
 var CNFLCT Status = Status{"CNFLCT", color.Yellow, 2}
 var CURRNT Status = Status{"CURRNT", color.Green, 3}
 var BEHIND Status = Status{"BEHIND", color.Yellow, 4}
+var STASHD Status = Status{"STASHD", color.Yellow, 5}
+var DRYRUN Status = Status{"DRYRUN", color.Cyan, 6}
+var INTRPT Status = Status{"INTRPT", color.Purple, 7}
+var AHEAD Status = Status{"AHEAD", color.Cyan, 8}
+var DIVRGD Status = Status{"DIVRGD", color.Yellow, 9}
+var DIRTY Status = Status{"DIRTY", color.Yellow, 10}
+var INPROG Status = Status{"INPROG", color.Red, 11}
+var SKIPPD Status = Status{"SKIPPD", color.Blue, 12}
 
 var config *ProgramConfig
 var repolist *RepoList
-var GOMAXPROCS int = 4
+
+// The number of repos worked on at once. Set via the --jobs,-j flag, the
+// config file, or DefaultJobs, in that order
+var Jobs int = 0
+
+const DefaultJobs int = 8
+
+// The longest a whole command may run. Set via the --timeout flag or the config
+// file, zero for no limit
+var Timeout time.Duration = 0
+
+// Skip fetching a remote fetched less than this long ago. Set via the
+// --fetch-ttl flag or the config file, zero to always fetch
+var FetchTTL time.Duration = 0
+
+// When each remote was last fetched, shared by all commands
+var fetches *FetchCache
+
+// Use the remote-tracking refs already fetched instead of updating remotes.
+// Set via the --offline or --no-fetch flag
+var Offline bool = false
+
+const DefaultCommandTimeout time.Duration = 10 * time.Minute
+
+// Stops the run context without it counting as an interrupt
+var cancelRun context.CancelFunc
 
 var RepolistFilename string = "repolist.json"
 
-func loadconfig() *ProgramConfig {
-	ex, _ := os.Executable()
-	configPath := filepath.Join(filepath.Dir(ex), "config.yaml")
-	yamlFile, err := ioutil.ReadFile(configPath)
+func loadconfig() (*ProgramConfig, error) {
+	configPath, err := FindConfig()
 	if err != nil {
-		log.Fatalln("The config.yaml file is missing!", err)
+		return nil, setupErrorf("Error finding the config file: %s", err)
 	}
-	var tempconfig ProgramConfig
-	err = yaml.Unmarshal(yamlFile, &tempconfig)
+	tempconfig, err := LoadConfig(configPath)
 	if err != nil {
-		panic(err)
+		return nil, setupErrorf("Error loading the config: %s", err)
 	}
-	if tempconfig.FCRemote == "" || tempconfig.RepoDir == "" || tempconfig.MasterBranch == "" {
-		log.Fatalln("The config.yaml is missing values!")
+	return tempconfig, nil
+}
+
+// Files kept by yeet for a workspace live in a .yeet directory in the repo directory
+func workspacePath(name string) string {
+	return filepath.Join(config.RepoDir, ".yeet", name)
+}
+
+// The repo list used to be kept next to the executable. Returns that path if
+// the list is only there and not yet at path.
+func legacyRepoListPath(path string) string {
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		return ""
+	}
+	ex, err := os.Executable()
+	if err != nil {
+		return ""
 	}
-	return &tempconfig
+	legacyPath := filepath.Join(filepath.Dir(ex), RepolistFilename)
+	if _, err := os.Stat(legacyPath); err != nil {
+		return ""
+	}
+	return legacyPath
 }
 
-func SetupCmd() {
-	config = loadconfig()
-	ex, _ := os.Executable()
-	repoListPath := filepath.Join(filepath.Dir(ex), RepolistFilename)
+func SetupCmd() error {
+	if !slices.Contains(outputFormats, Output) {
+		return setupErrorf("Unknown output format %s, expected one of %v", Output, outputFormats)
+	}
+	c, err := loadconfig()
+	if err != nil {
+		return err
+	}
+	config = c
+	repoListPath := workspacePath(RepolistFilename)
+	if legacyPath := legacyRepoListPath(repoListPath); legacyPath != "" {
+		logf("Using %s, run `yeet refresh` to move it to %s\n", legacyPath, repoListPath)
+		repoListPath = legacyPath
+	}
 	sw := SoloWorker{repoListPath, config.RepoDir}
 	r, err := sw.GetList()
 	if err != nil {
-		msg := fmt.Sprintf("Error loading %s, you may need to run `yeet refresh` first?", RepolistFilename)
-		log.Fatalln(msg)
+		return setupErrorf("Error loading %s, you may need to run `yeet refresh` first?", RepolistFilename)
 	}
 	repolist = r
+	if !Filter.Empty() {
+		repolist = &RepoList{Filter.Apply(r.RepoList)}
+		if len(repolist.RepoList) == 0 {
+			return setupErrorf("No repos match the given --group, --project and --path selectors")
+		}
+	}
+	if Jobs <= 0 {
+		Jobs = config.Jobs
+	}
+	if Jobs <= 0 {
+		Jobs = DefaultJobs
+	}
+	if Timeout <= 0 {
+		Timeout = config.Timeout
+	}
+	if CommandTimeout <= 0 {
+		CommandTimeout = config.CommandTimeout
+	}
+	if CommandTimeout <= 0 {
+		CommandTimeout = DefaultCommandTimeout
+	}
+	if FetchTTL <= 0 {
+		FetchTTL = config.FetchTTL
+	}
+	fetches = LoadFetchCache(workspacePath(FetchCacheFilename))
+	startRunContext()
+	return nil
+}
+
+// Cancels the run context on SIGINT or when the overall timeout runs out. Once
+// cancelled no new repos are started, and a second SIGINT kills yeet outright.
+func startRunContext() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	cancel := stop
+	if Timeout > 0 {
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = context.WithTimeout(ctx, Timeout)
+		cancel = func() {
+			cancelTimeout()
+			stop()
+		}
+	}
+	runContext = ctx
+	finished := make(chan struct{})
+	cancelRun = func() {
+		close(finished)
+		cancel()
+	}
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-finished:
+			return
+		}
+		stop()
+		select {
+		case <-finished:
+			// Cancelled by FinishCmd, not an interrupt
+		default:
+			logf("\n%s, cleaning up running repos...\n", color.InPurple(interruptReason()))
+		}
+	}()
+}
+
+// Releases the signal handler and timer of the run once the command is done
+func FinishCmd() {
+	if cancelRun != nil {
+		cancelRun()
+		cancelRun = nil
+	}
+	runContext = context.Background()
+}
+
+func interrupted() bool {
+	return runContext.Err() != nil
+}
+
+func interruptReason() string {
+	if runContext.Err() == context.DeadlineExceeded {
+		return "Timed out"
+	}
+	return "Interrupted"
 }
 
-func RefreshCmd() {
-	config = loadconfig()
-	ex, _ := os.Executable()
-	repoListPath := filepath.Join(filepath.Dir(ex), RepolistFilename)
+func RefreshCmd() error {
+	c, err := loadconfig()
+	if err != nil {
+		return err
+	}
+	config = c
+	repoListPath := workspacePath(RepolistFilename)
 	sw := SoloWorker{repoListPath, config.RepoDir}
-	err := sw.Refresh()
+	err = sw.Refresh()
+	if err != nil {
+		return setupErrorf("Error refreshing the repo list: %s", err)
+	}
+	r, err := sw.GetList()
 	if err != nil {
-		panic(err)
+		return setupErrorf("Error loading %s: %s", repoListPath, err)
 	}
-	r, _ := sw.GetList()
 	n := len(r.RepoList)
-	fmt.Printf("Loaded %d repositories into %s.", n, repoListPath)
+	fmt.Printf("Loaded %d repositories into %s.\n", n, repoListPath)
+	return nil
 }
 
-func TakeCmd(target string) {
-	runtime.GOMAXPROCS(GOMAXPROCS)
-	numCPUs := strconv.Itoa(GOMAXPROCS)
-	fmt.Printf("Checking out any %s branches using %s CPUs...\n", color.InYellow(target), color.InYellow(numCPUs))
+func TakeCmd(targets []string, opts *TakeOptions) error {
+	if opts.Strategy == "" {
+		opts.Strategy = config.Strategy
+	}
+	if opts.Strategy == "" {
+		opts.Strategy = StrategyRebase
+	}
+	if !slices.Contains(Strategies, opts.Strategy) {
+		return setupErrorf("Unknown strategy %s, expected one of %v", opts.Strategy, Strategies)
+	}
+	if opts.GerritTopic != "" || len(opts.Changes) > 0 {
+		if Offline {
+			return setupErrorf("Gerrit changes have to be fetched, --offline cannot be used with --gerrit-topic or --change")
+		}
+		branch, err := resolveGerritChanges(opts)
+		if err != nil {
+			return err
+		}
+		targets = []string{branch}
+	}
+	target := strings.Join(targets, " ")
+	opts.RestoreStash = opts.RestoreStash || config.RestoreStash
+	numJobs := strconv.Itoa(Jobs)
+	if opts.gerritChanges != nil {
+		n := 0
+		for _, changes := range opts.gerritChanges {
+			n += len(changes)
+		}
+		logf("Taking %d Gerrit changes in %d projects onto branch %s...\n", n, len(opts.gerritChanges), color.InYellow(target))
+	}
+	if opts.DryRun {
+		logf("Planning checkout of any %s branches using %s jobs, nothing will be changed...\n", color.InYellow(target), color.InYellow(numJobs))
+	} else {
+		logf("Checking out any %s branches using %s jobs...\n", color.InYellow(target), color.InYellow(numJobs))
+	}
 	start := time.Now()
+	reporter := NewReporter()
 	done := make(chan *WorkFlowResult)
 	defer close(done)
+	pool := NewWorkerPool(Jobs)
+	if opts.DryRun {
+		for _, r := range repolist.RepoList {
+			init := &RepoWorkerInitializer{r}
+			pool.Go(func() { planWorkflow(targets, init, opts, done) })
+		}
+		logf("Queued %d repos...\n", len(repolist.RepoList))
+		for i := 0; i < len(repolist.RepoList); i++ {
+			result := <-done
+			reporter.Report(result)
+		}
+		reporter.Close()
+		elapsed := time.Since(start)
+		logf("Done, took %s\n", elapsed)
+		return reporter.Err()
+	}
+	journal := NewJournal(workspacePath(JournalFilename), target)
+	if err := journal.Save(); err != nil {
+		return setupErrorf("Error writing the take journal: %s", err)
+	}
 	var n int = len(repolist.RepoList)
 	for _, r := range repolist.RepoList {
 		init := &RepoWorkerInitializer{r}
-		go takeWorkflow(target, init, done)
+		pool.Go(func() { takeWorkflow(targets, init, journal, opts, done) })
+	}
+	logf("Queued %d repos...\n", n)
+
+	stopped := 0
+	for i := 0; i < n; i++ {
+		result := <-done
+		if result.Status == INTRPT {
+			stopped++
+		}
+		reporter.Report(result)
+	}
+	if stopped > 0 {
+		logf("%s: %d of %d repos did not finish, run `yeet undo` to roll back\n", interruptReason(), stopped, n)
+	}
+	if opts.KeepConflicts && len(journal.PendingEntries()) > 0 {
+		logf("%d repos were left with conflicts open, resolve them and run `yeet continue`, or `yeet abort`\n", len(journal.PendingEntries()))
+	}
+
+	reporter.Close()
+	elapsed := time.Since(start)
+	logf("Done, took %s\n", elapsed)
+	return reporter.Err()
+}
+
+// Looks up the changes to take from Gerrit and returns the local branch to
+// take them onto: the topic name, or change-<number> for single changes
+func resolveGerritChanges(opts *TakeOptions) (string, error) {
+	if config.GerritURL == "" {
+		return "", setupErrorf("No Gerrit server configured, set gerrit in the config file")
+	}
+	client := NewGerritClient(config.GerritURL)
+	client.Username = config.GerritUser
+	client.Password = config.GerritPassword
+	changes := make([]*GerritChange, 0)
+	branch := opts.GerritTopic
+	if opts.GerritTopic != "" {
+		topicChanges, err := client.TopicChanges(opts.GerritTopic)
+		if err != nil {
+			return "", setupErrorf("Error querying Gerrit: %s", err)
+		}
+		changes = append(changes, topicChanges...)
+	}
+	for _, spec := range opts.Changes {
+		change, err := client.Change(spec)
+		if err != nil {
+			return "", setupErrorf("Error querying Gerrit: %s", err)
+		}
+		if branch == "" {
+			branch = fmt.Sprintf("change-%d", change.Number)
+		}
+		if slices.IndexFunc(changes, func(c *GerritChange) bool { return c.Number == change.Number }) < 0 {
+			changes = append(changes, change)
+		}
+	}
+	opts.gerritChanges = GroupChanges(changes)
+	// Changes in projects outside the repo list would silently be left out
+	for project := range opts.gerritChanges {
+		if slices.IndexFunc(repolist.RepoList, func(r *RepoInfo) bool { return r.Name == project }) < 0 {
+			logf("%s: project %s is not in the repo list, its changes are skipped\n", color.InYellow("Warning"), project)
+		}
+	}
+	return branch, nil
+}
+
+func UndoCmd() error {
+	journal, err := LoadJournal(workspacePath(JournalFilename))
+	if err != nil {
+		return setupErrorf("No take to undo, the journal could not be loaded: %s", err)
+	}
+	logf("Undoing take of %s from %s using %s jobs...\n", color.InYellow(journal.Target), journal.Time.Format(time.RFC1123), color.InYellow(strconv.Itoa(Jobs)))
+	start := time.Now()
+	reporter := NewReporter()
+	done := make(chan *WorkFlowResult)
+	defer close(done)
+	pool := NewWorkerPool(Jobs)
+	var n int = len(journal.Entries)
+	for _, e := range journal.Entries {
+		entry := e
+		pool.Go(func() { undoWorkflow(entry, done) })
 	}
-	fmt.Printf("Started %d processes...\n", n)
+	logf("Queued %d repos...\n", n)
 
+	failed := false
 	for i := 0; i < n; i++ {
 		result := <-done
-		fmt.Print(result.Format())
+		if result.Status != PASSED {
+			failed = true
+		}
+		reporter.Report(result)
+	}
+	// Keep the journal around so a partial undo can be retried
+	if !failed {
+		_ = journal.Remove()
+	}
+
+	reporter.Close()
+	elapsed := time.Since(start)
+	logf("Done, took %s\n", elapsed)
+	return reporter.Err()
+}
+
+func ContinueCmd() error {
+	journal, err := LoadJournal(workspacePath(JournalFilename))
+	if err != nil {
+		return setupErrorf("No take to continue, the journal could not be loaded: %s", err)
+	}
+	entries := journal.PendingEntries()
+	if len(entries) == 0 {
+		return setupErrorf("The last take of %s has no conflicts left open", journal.Target)
+	}
+	logf("Continuing take of %s in %d repos using %s jobs...\n", color.InYellow(journal.Target), len(entries), color.InYellow(strconv.Itoa(Jobs)))
+	start := time.Now()
+	reporter := NewReporter()
+	done := make(chan *WorkFlowResult)
+	defer close(done)
+	pool := NewWorkerPool(Jobs)
+	for _, e := range entries {
+		entry := e
+		// The take needs the manifest details of the repo, such as its base branch
+		info := &RepoInfo{Path: entry.Path, Name: entry.Name}
+		if i := slices.IndexFunc(repolist.RepoList, func(r *RepoInfo) bool { return r.Path == entry.Path }); i >= 0 {
+			info = repolist.RepoList[i]
+		}
+		pool.Go(func() { continueWorkflow(entry, info, journal, done) })
+	}
+	for i := 0; i < len(entries); i++ {
+		reporter.Report(<-done)
+	}
+	reporter.Close()
+	if remaining := len(journal.PendingEntries()); remaining > 0 {
+		logf("%d repos still have conflicts open\n", remaining)
 	}
+	elapsed := time.Since(start)
+	logf("Done, took %s\n", elapsed)
+	return reporter.Err()
+}
 
+func AbortCmd() error {
+	journal, err := LoadJournal(workspacePath(JournalFilename))
+	if err != nil {
+		return setupErrorf("No take to abort, the journal could not be loaded: %s", err)
+	}
+	entries := journal.PendingEntries()
+	if len(entries) == 0 {
+		return setupErrorf("The last take of %s has no conflicts left open", journal.Target)
+	}
+	logf("Aborting take of %s in %d repos using %s jobs...\n", color.InYellow(journal.Target), len(entries), color.InYellow(strconv.Itoa(Jobs)))
+	start := time.Now()
+	reporter := NewReporter()
+	done := make(chan *WorkFlowResult)
+	defer close(done)
+	pool := NewWorkerPool(Jobs)
+	for _, e := range entries {
+		entry := e
+		pool.Go(func() { abortWorkflow(entry, journal, done) })
+	}
+	for i := 0; i < len(entries); i++ {
+		reporter.Report(<-done)
+	}
+	reporter.Close()
 	elapsed := time.Since(start)
-	fmt.Printf("Done, took %s", elapsed)
+	logf("Done, took %s\n", elapsed)
+	return reporter.Err()
 }
 
-func StatusCmd() {
-	runtime.GOMAXPROCS(GOMAXPROCS)
-	numCPUs := strconv.Itoa(GOMAXPROCS)
-	fmt.Printf("Checking hashes using %s CPUs...\n", color.InYellow(numCPUs))
+func StatusCmd() error {
+	numJobs := strconv.Itoa(Jobs)
+	logf("Checking hashes using %s jobs...\n", color.InYellow(numJobs))
 	start := time.Now()
+	reporter := NewReporter()
 	done := make(chan *WorkFlowResult)
 	defer close(done)
+	pool := NewWorkerPool(Jobs)
 	var n int = len(repolist.RepoList)
 	for _, r := range repolist.RepoList {
 		init := &RepoWorkerInitializer{r}
-		go statusWorkflow(init, done)
+		pool.Go(func() { statusWorkflow(init, done) })
 	}
-	fmt.Printf("Started %d processes...\n", n)
+	logf("Queued %d repos...\n", n)
 
 	for i := 0; i < n; i++ {
 		result := <-done
-		fmt.Print(result.Format())
+		reporter.Report(result)
 	}
 
+	reporter.Close()
 	elapsed := time.Since(start)
-	fmt.Printf("Done, took %s", elapsed)
+	logf("Done, took %s\n", elapsed)
+	return reporter.Err()
 }
 
-func FindCmd(target string) {
-	runtime.GOMAXPROCS(GOMAXPROCS)
-	numCPUs := strconv.Itoa(GOMAXPROCS)
-	fmt.Printf("Searching for branch %s using %s CPUs...\n", color.InYellow(target), color.InYellow(numCPUs))
+// Fetches every repo and records when, so that other commands can skip
+// fetching for the fetch TTL
+func FetchCmd() error {
+	logf("Fetching all repos using %s jobs...\n", color.InYellow(strconv.Itoa(Jobs)))
 	start := time.Now()
+	reporter := NewReporter()
+	done := make(chan *WorkFlowResult)
+	defer close(done)
+	pool := NewWorkerPool(Jobs)
+	var n int = len(repolist.RepoList)
+	for _, r := range repolist.RepoList {
+		init := &RepoWorkerInitializer{r}
+		pool.Go(func() { fetchWorkflow(init, done) })
+	}
+	logf("Queued %d repos...\n", n)
+
+	for i := 0; i < n; i++ {
+		result := <-done
+		reporter.Report(result)
+	}
+
+	reporter.Close()
+	elapsed := time.Since(start)
+	logf("Done, took %s\n", elapsed)
+	return reporter.Err()
+}
+
+// Lists the branches matching a glob, or a regex if regex is set, across all repos
+func FindCmd(target string, regex bool) error {
+	pattern, err := BranchPattern(target, regex)
+	if err != nil {
+		return setupErrorf("%s", err)
+	}
+	numJobs := strconv.Itoa(Jobs)
+	logf("Searching for branches matching %s using %s jobs...\n", color.InYellow(target), color.InYellow(numJobs))
+	start := time.Now()
+	reporter := NewReporter()
 	found := false
 	nameChan := make(chan *SearchResult)
 	wg := sync.WaitGroup{}
+	pool := NewWorkerPool(Jobs)
 	for _, r := range repolist.RepoList {
 		wg.Add(1)
 		init := &RepoWorkerInitializer{r}
-		go findWorkflow(target, init, nameChan, &wg)
+		pool.Go(func() { findWorkflow(pattern, init, nameChan, &wg) })
 	}
-	fmt.Printf("Started %d processes...\n", len(repolist.RepoList))
+	logf("Queued %d repos...\n", len(repolist.RepoList))
 
 	go func() {
 		wg.Wait()
@@ -182,13 +709,248 @@ func FindCmd(target string) {
 
 	for name := range nameChan {
 		found = true
-		fmt.Println(name.ToString())
+		reporter.Report(name)
+	}
+
+	if !found {
+		logf("Nothing found for %s\n", target)
+	}
+
+	reporter.Close()
+	elapsed := time.Since(start)
+	logf("Done, took %s\n", elapsed)
+	return reporter.Err()
+}
+
+// Brings every repo to the tip of its base branch, leaving repos with local
+// changes alone unless force is set
+func SyncCmd(force bool) error {
+	strategy := config.Strategy
+	if strategy == "" {
+		strategy = StrategyRebase
+	}
+	if !slices.Contains(Strategies, strategy) {
+		return setupErrorf("Unknown strategy %s, expected one of %v", strategy, Strategies)
+	}
+	logf("Syncing all repos to their base branch using %s jobs...\n", color.InYellow(strconv.Itoa(Jobs)))
+	start := time.Now()
+	reporter := NewReporter()
+	done := make(chan *WorkFlowResult)
+	defer close(done)
+	pool := NewWorkerPool(Jobs)
+	var n int = len(repolist.RepoList)
+	for _, r := range repolist.RepoList {
+		init := &RepoWorkerInitializer{r}
+		pool.Go(func() { syncWorkflow(force, strategy, init, done) })
+	}
+	logf("Queued %d repos...\n", n)
+
+	skipped := 0
+	for i := 0; i < n; i++ {
+		result := <-done
+		if result.Status == SKIPPD {
+			skipped++
+		}
+		reporter.Report(result)
+	}
+	if skipped > 0 {
+		logf("%d repos with local work were left alone\n", skipped)
+	}
+
+	reporter.Close()
+	elapsed := time.Since(start)
+	logf("Done, took %s\n", elapsed)
+	return reporter.Err()
+}
+
+// Creates a topic branch from the base branch in the selected repos, or only
+// those with local changes if changed is set
+func StartCmd(branch string, changed bool) error {
+	if Filter.Empty() && !changed {
+		return setupErrorf("Choose the repos to start %s in with --group, --project, --path or --changed", branch)
+	}
+	logf("Starting %s using %s jobs...\n", color.InYellow(branch), color.InYellow(strconv.Itoa(Jobs)))
+	start := time.Now()
+	reporter := NewReporter()
+	done := make(chan *WorkFlowResult)
+	defer close(done)
+	pool := NewWorkerPool(Jobs)
+	var n int = len(repolist.RepoList)
+	for _, r := range repolist.RepoList {
+		init := &RepoWorkerInitializer{r}
+		pool.Go(func() { startWorkflow(branch, changed, init, done) })
+	}
+	logf("Queued %d repos...\n", n)
+
+	for i := 0; i < n; i++ {
+		result := <-done
+		reporter.Report(result)
+	}
+
+	reporter.Close()
+	elapsed := time.Since(start)
+	logf("Done, took %s\n", elapsed)
+	return reporter.Err()
+}
+
+// Pushes the branch, or each repo's current topic branch if none is given,
+// from every repo that has it
+func PushCmd(branch string, setUpstream bool) error {
+	if branch == "" {
+		logf("Pushing the current branch of every repo using %s jobs...\n", color.InYellow(strconv.Itoa(Jobs)))
+	} else {
+		logf("Pushing %s using %s jobs...\n", color.InYellow(branch), color.InYellow(strconv.Itoa(Jobs)))
+	}
+	start := time.Now()
+	reporter := NewReporter()
+	done := make(chan *WorkFlowResult)
+	defer close(done)
+	pool := NewWorkerPool(Jobs)
+	var n int = len(repolist.RepoList)
+	for _, r := range repolist.RepoList {
+		init := &RepoWorkerInitializer{r}
+		pool.Go(func() { pushWorkflow(branch, setUpstream, init, done) })
+	}
+	logf("Queued %d repos...\n", n)
+
+	for i := 0; i < n; i++ {
+		result := <-done
+		reporter.Report(result)
+	}
+
+	reporter.Close()
+	elapsed := time.Since(start)
+	logf("Done, took %s\n", elapsed)
+	return reporter.Err()
+}
+
+// Lists the remote branches found in at least minRepos repos, fetching each
+// repo once
+func TopicsCmd(minRepos int) error {
+	if minRepos < 1 {
+		return setupErrorf("--min-repos must be at least 1, got %d", minRepos)
+	}
+	logf("Looking for branches in %s or more repos using %s jobs...\n", color.InYellow(strconv.Itoa(minRepos)), color.InYellow(strconv.Itoa(Jobs)))
+	start := time.Now()
+	reporter := NewReporter()
+	done := make(chan *repoBranches)
+	defer close(done)
+	pool := NewWorkerPool(Jobs)
+	var n int = len(repolist.RepoList)
+	for _, r := range repolist.RepoList {
+		init := &RepoWorkerInitializer{r}
+		pool.Go(func() { topicsWorkflow(init, done) })
+	}
+	logf("Queued %d repos...\n", n)
+
+	branches := make(map[string]map[string]*BranchRef)
+	cached := 0
+	for i := 0; i < n; i++ {
+		result := <-done
+		if result.err != nil {
+			reporter.Report(&SearchResult{RepoName: result.info.Name, Path: result.info.Path, Error: result.err.Error()})
+			continue
+		}
+		branches[result.info.Name] = result.branches
+		if result.cached {
+			cached++
+		}
+	}
+	if cached > 0 {
+		logf("%d repos were not fetched, using the remote branches from their last fetch\n", cached)
+	}
+	topics := FindTopics(branches, minRepos)
+	for _, topic := range topics {
+		reporter.Report(topic)
+	}
+	if len(topics) == 0 {
+		logf("No branches found in %d or more repos\n", minRepos)
+	}
+
+	reporter.Close()
+	elapsed := time.Since(start)
+	logf("Done, took %s\n", elapsed)
+	return reporter.Err()
+}
+
+// Runs a command in every repo, through the shell if it is a single argument.
+// Output lines are prefixed with the repo name as they come, or printed a
+// repo at a time with groupOutput, and the failures are listed at the end.
+func ExecCmd(args []string, groupOutput bool) error {
+	if len(args) == 1 {
+		args = shellArgs(args[0])
+	}
+	logf("Running %s using %s jobs...\n", color.InYellow(strings.Join(args, " ")), color.InYellow(strconv.Itoa(Jobs)))
+	start := time.Now()
+	reporter := NewReporter()
+	stream := Output == "text" && !groupOutput
+	done := make(chan *ExecResult)
+	defer close(done)
+	pool := NewWorkerPool(Jobs)
+	var n int = len(repolist.RepoList)
+	for _, r := range repolist.RepoList {
+		init := &RepoWorkerInitializer{r}
+		pool.Go(func() { execWorkflow(args, stream, init, done) })
+	}
+	logf("Queued %d repos...\n", n)
+
+	failed := make([]*ExecResult, 0)
+	for i := 0; i < n; i++ {
+		result := <-done
+		if result.Failed() {
+			failed = append(failed, result)
+		}
+		reporter.Report(result)
+	}
+	reporter.Close()
+	if len(failed) > 0 {
+		sort.Slice(failed, func(i, j int) bool { return failed[i].RepoName < failed[j].RepoName })
+		logf("%d repos failed:\n", len(failed))
+		for _, result := range failed {
+			if result.Error != "" {
+				logf("  %s: %s\n", color.InYellow(result.RepoName), color.InRed(result.Error))
+			} else {
+				logf("  %s: exit code %s\n", color.InYellow(result.RepoName), color.InRed(strconv.Itoa(result.ExitCode)))
+			}
+		}
+	}
+	elapsed := time.Since(start)
+	logf("Done, took %s\n", elapsed)
+	return reporter.Err()
+}
+
+// Lists, pops or drops the stashes yeet has made across all repos
+func StashCmd(action string, all bool) error {
+	logf("Running stash %s across all repos using %s jobs...\n", color.InYellow(action), color.InYellow(strconv.Itoa(Jobs)))
+	start := time.Now()
+	reporter := NewReporter()
+	found := false
+	results := make(chan *WorkFlowResult)
+	wg := sync.WaitGroup{}
+	pool := NewWorkerPool(Jobs)
+	for _, r := range repolist.RepoList {
+		wg.Add(1)
+		init := &RepoWorkerInitializer{r}
+		pool.Go(func() { stashWorkflow(action, all, init, results, &wg) })
+	}
+	logf("Queued %d repos...\n", len(repolist.RepoList))
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	for result := range results {
+		found = true
+		reporter.Report(result)
 	}
 
 	if !found {
-		fmt.Printf("Nothing found for %s\n", target)
+		logf("No yeet stashes found\n")
 	}
 
+	reporter.Close()
 	elapsed := time.Since(start)
-	fmt.Printf("Done, took %s", elapsed)
+	logf("Done, took %s\n", elapsed)
+	return reporter.Err()
 }
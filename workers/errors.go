@@ -0,0 +1,60 @@
+package workers
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Exit codes, so that scripts and CI can branch on the result of a command
+const (
+	ExitPassed    int = 0
+	ExitFailures  int = 1
+	ExitConflicts int = 2
+	ExitSetup     int = 3
+)
+
+// A problem with the arguments, config or repo list, found before any repo was touched
+type SetupError struct {
+	Err error
+}
+
+func setupErrorf(format string, a ...interface{}) *SetupError {
+	return &SetupError{fmt.Errorf(format, a...)}
+}
+
+func (e *SetupError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *SetupError) Unwrap() error {
+	return e.Err
+}
+
+func (e *SetupError) ExitCode() int {
+	return ExitSetup
+}
+
+// Returned when a command ran but some repos did not pass. Interrupted repos
+// count as failures.
+type ResultError struct {
+	Failures  int
+	Conflicts int
+}
+
+func (e *ResultError) Error() string {
+	parts := make([]string, 0)
+	if e.Failures > 0 {
+		parts = append(parts, fmt.Sprintf("%d repos failed", e.Failures))
+	}
+	if e.Conflicts > 0 {
+		parts = append(parts, fmt.Sprintf("%d repos have conflicts", e.Conflicts))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func (e *ResultError) ExitCode() int {
+	if e.Failures > 0 {
+		return ExitFailures
+	}
+	return ExitConflicts
+}
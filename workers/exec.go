@@ -0,0 +1,127 @@
+package workers
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/TwiN/go-color"
+)
+
+// What yeet exec did in one repo. Unless the output is grouped or printed as
+// JSON, lines are printed as they come and not kept.
+type ExecResult struct {
+	RepoName string   `json:"name"`
+	Path     string   `json:"path"`
+	ExitCode int      `json:"exit_code"`
+	Error    string   `json:"error,omitempty"`
+	Output   []string `json:"output"`
+	streamed bool
+}
+
+func (r *ExecResult) Format() string {
+	var sb strings.Builder
+	if !r.streamed {
+		sb.WriteString(color.Yellow + r.RepoName + color.Reset + ":\n")
+		for _, line := range r.Output {
+			sb.WriteString("  " + line + "\n")
+		}
+	}
+	if r.Error != "" {
+		sb.WriteString(color.Yellow + r.RepoName + color.Reset + ": " + color.Red + r.Error + color.Reset + "\n")
+	}
+	return sb.String()
+}
+
+func (r *ExecResult) Failed() bool {
+	return r.ExitCode != 0 || r.Error != ""
+}
+
+// Keeps lines streamed from different repos from running into each other
+var streamMu sync.Mutex
+
+func streamLine(name string, line string) {
+	streamMu.Lock()
+	defer streamMu.Unlock()
+	fmt.Fprintln(os.Stdout, color.Yellow+name+color.Reset+": "+line)
+}
+
+// The environment the command runs with, as `repo forall` sets it
+func execEnv(info *RepoInfo, remote string) []string {
+	path := info.RelPath
+	if path == "" {
+		path = info.Path
+	}
+	return append(os.Environ(), "REPO_PATH="+path, "REPO_PROJECT="+info.Name, "REPO_REMOTE="+remote)
+}
+
+// Runs the command in the repo with stdout and stderr merged. Unlike git
+// commands it is not bound by CommandTimeout, as it may well be a build, but
+// it is killed when the run is interrupted or times out.
+func execWorkflow(args []string, stream bool, init *RepoWorkerInitializer, done chan<- *ExecResult) {
+	result := &ExecResult{RepoName: init.RepoInfo.Name, Path: init.RepoInfo.Path, Output: make([]string, 0), streamed: stream}
+	if interrupted() {
+		result.ExitCode, result.Error = -1, "Not started"
+		done <- result
+		return
+	}
+	remote := init.RepoInfo.Remote
+	if remote == "" {
+		if remotes, err := init.Remotes(); err == nil {
+			remote, _ = selectRemote(remotes)
+		}
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Dir = init.RepoInfo.Path
+	cmd.Env = execEnv(init.RepoInfo, remote)
+	setProcessGroup(cmd)
+	rd, wr, err := os.Pipe()
+	if err != nil {
+		result.ExitCode, result.Error = -1, err.Error()
+		done <- result
+		return
+	}
+	cmd.Stdout, cmd.Stderr = wr, wr
+	err = cmd.Start()
+	wr.Close()
+	if err != nil {
+		rd.Close()
+		result.ExitCode, result.Error = -1, err.Error()
+		done <- result
+		return
+	}
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-runContext.Done():
+			killProcessGroup(cmd)
+		case <-finished:
+		}
+	}()
+	scanner := bufio.NewScanner(rd)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if stream {
+			streamLine(result.RepoName, line)
+		} else {
+			result.Output = append(result.Output, line)
+		}
+	}
+	rd.Close()
+	if err := cmd.Wait(); err != nil {
+		if exitError, ok := err.(*exec.ExitError); ok {
+			result.ExitCode = exitError.ExitCode()
+		} else {
+			result.ExitCode, result.Error = -1, err.Error()
+		}
+	}
+	if interrupted() && result.ExitCode != 0 {
+		result.Error = interruptReason()
+	}
+	done <- result
+}
@@ -0,0 +1,72 @@
+package workers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+var FetchCacheFilename string = "fetch.json"
+
+// Records when each remote of each repo was last fetched, so that commands run
+// one after another do not all fetch the whole workspace again
+type FetchCache struct {
+	// Keyed by repo path, then remote
+	Fetches map[string]map[string]time.Time `json:"fetches"`
+	path    string
+	mu      sync.Mutex
+}
+
+// Loads the cache, starting an empty one if it is missing or unreadable since
+// the worst that can happen then is an extra fetch
+func LoadFetchCache(path string) *FetchCache {
+	cache := &FetchCache{Fetches: make(map[string]map[string]time.Time), path: path}
+	file, err := ioutil.ReadFile(path)
+	if err != nil {
+		return cache
+	}
+	if err := json.Unmarshal(file, cache); err != nil || cache.Fetches == nil {
+		cache.Fetches = make(map[string]map[string]time.Time)
+	}
+	return cache
+}
+
+// When the remote of the repo at path was last fetched, zero if never
+func (c *FetchCache) LastFetch(path string, remote string) time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Fetches[path][remote]
+}
+
+// Whether the remote was fetched less than ttl ago. A ttl of zero or less
+// means fetching every time.
+func (c *FetchCache) Fresh(path string, remote string, ttl time.Duration) bool {
+	if ttl <= 0 {
+		return false
+	}
+	last := c.LastFetch(path, remote)
+	return !last.IsZero() && time.Since(last) < ttl
+}
+
+// Notes a successful fetch and writes the cache to disk straight away, as
+// repos finish at different times and the run may be interrupted
+func (c *FetchCache) Record(path string, remote string, when time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.Fetches[path] == nil {
+		c.Fetches[path] = make(map[string]time.Time)
+	}
+	c.Fetches[path][remote] = when
+	return c.save()
+}
+
+func (c *FetchCache) save() error {
+	if err := os.MkdirAll(filepath.Dir(c.path), 0755); err != nil {
+		return err
+	}
+	jsontext, _ := json.MarshalIndent(c, "", "\t")
+	return ioutil.WriteFile(c.path, jsontext, 0644)
+}
@@ -0,0 +1,32 @@
+package workers_test
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+	workers "yeet/workers"
+)
+
+func TestFetchCache(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".yeet", "fetch.json")
+	cache := workers.LoadFetchCache(path)
+	if cache.Fresh("/src/a", "origin", time.Hour) {
+		t.Fatalf(`A repo that was never fetched should not be fresh`)
+	}
+	if err := cache.Record("/src/a", "origin", time.Now().Add(-10*time.Minute)); err != nil {
+		t.Fatalf(`Error recording fetch: %s`, err)
+	}
+	cache = workers.LoadFetchCache(path)
+	if !cache.Fresh("/src/a", "origin", time.Hour) {
+		t.Fatalf(`Fetch 10m ago should be fresh for an hour`)
+	}
+	if cache.Fresh("/src/a", "origin", 5*time.Minute) {
+		t.Fatalf(`Fetch 10m ago should be stale after 5m`)
+	}
+	if cache.Fresh("/src/a", "origin", 0) {
+		t.Fatalf(`A zero TTL should always fetch`)
+	}
+	if cache.Fresh("/src/a", "upstream", time.Hour) || cache.Fresh("/src/b", "origin", time.Hour) {
+		t.Fatalf(`Only the fetched remote of the fetched repo should be fresh`)
+	}
+}
@@ -0,0 +1,86 @@
+package workers
+
+import (
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/exp/slices"
+)
+
+// Narrows the repo list down to the repos a command should touch. Set via the
+// --group, --project and --path flags.
+var Filter RepoFilter
+
+// Exported struct
+type RepoFilter struct {
+	// Manifest groups with the semantics of `repo sync -g`, "-name" excludes a group
+	Groups []string
+	// Project names from the manifest
+	Projects []string
+	// Project paths relative to the workspace, including anything below them
+	Paths []string
+}
+
+func (f *RepoFilter) Empty() bool {
+	return len(f.Groups) == 0 && len(f.Projects) == 0 && len(f.Paths) == 0
+}
+
+// A repo must match the groups, if any are given, and one of the projects or
+// paths, if any are given
+func (f *RepoFilter) Matches(info *RepoInfo) bool {
+	if len(f.Groups) > 0 && !matchesGroups(info, f.Groups) {
+		return false
+	}
+	if len(f.Projects) == 0 && len(f.Paths) == 0 {
+		return true
+	}
+	if slices.Contains(f.Projects, info.Name) {
+		return true
+	}
+	relPath := filepath.ToSlash(info.RelPath)
+	for _, p := range f.Paths {
+		if filepath.IsAbs(p) {
+			if info.Path == filepath.Clean(p) {
+				return true
+			}
+			continue
+		}
+		p = strings.TrimSuffix(filepath.ToSlash(filepath.Clean(p)), "/")
+		if relPath == p || strings.HasPrefix(relPath, p+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *RepoFilter) Apply(list []*RepoInfo) []*RepoInfo {
+	if f.Empty() {
+		return list
+	}
+	selected := make([]*RepoInfo, 0)
+	for _, info := range list {
+		if f.Matches(info) {
+			selected = append(selected, info)
+		}
+	}
+	return selected
+}
+
+// The group matching done by the repo tool. Every project is implicitly in
+// "all", "name:<name>", "path:<path>", and in "default" unless it is in
+// "notdefault". Later groups win, so "all,-ui" is everything outside ui.
+func matchesGroups(info *RepoInfo, groups []string) bool {
+	expanded := append([]string{"all", "name:" + info.Name, "path:" + info.RelPath}, info.Groups...)
+	if !slices.Contains(expanded, "notdefault") {
+		expanded = append(expanded, "default")
+	}
+	matched := false
+	for _, group := range groups {
+		if strings.HasPrefix(group, "-") && slices.Contains(expanded, group[1:]) {
+			matched = false
+		} else if slices.Contains(expanded, group) {
+			matched = true
+		}
+	}
+	return matched
+}
@@ -0,0 +1,48 @@
+package workers_test
+
+import (
+	"testing"
+	workers "yeet/workers"
+)
+
+func filterNames(f workers.RepoFilter, list []*workers.RepoInfo) []string {
+	names := make([]string, 0)
+	for _, info := range f.Apply(list) {
+		names = append(names, info.Name)
+	}
+	return names
+}
+
+func TestRepoFilter(t *testing.T) {
+	list := []*workers.RepoInfo{
+		{Name: "platform/core", RelPath: "platform/core", Groups: []string{"platform"}},
+		{Name: "platform/hal", RelPath: "platform/hal", Groups: []string{"platform", "notdefault"}},
+		{Name: "ui/app", RelPath: "apps/ui", Groups: []string{"ui"}},
+		{Name: "tools", RelPath: "tools"},
+	}
+	cases := []struct {
+		filter workers.RepoFilter
+		want   []string
+	}{
+		{workers.RepoFilter{}, []string{"platform/core", "platform/hal", "ui/app", "tools"}},
+		{workers.RepoFilter{Groups: []string{"platform"}}, []string{"platform/core", "platform/hal"}},
+		{workers.RepoFilter{Groups: []string{"default"}}, []string{"platform/core", "ui/app", "tools"}},
+		{workers.RepoFilter{Groups: []string{"all", "-platform"}}, []string{"ui/app", "tools"}},
+		{workers.RepoFilter{Groups: []string{"-platform", "all"}}, []string{"platform/core", "platform/hal", "ui/app", "tools"}},
+		{workers.RepoFilter{Groups: []string{"name:tools", "path:apps/ui"}}, []string{"ui/app", "tools"}},
+		{workers.RepoFilter{Projects: []string{"ui/app"}, Paths: []string{"platform"}}, []string{"platform/core", "platform/hal", "ui/app"}},
+		{workers.RepoFilter{Groups: []string{"default"}, Paths: []string{"platform/"}}, []string{"platform/core"}},
+		{workers.RepoFilter{Paths: []string{"plat"}}, []string{}},
+	}
+	for i, c := range cases {
+		got := filterNames(c.filter, list)
+		if len(got) != len(c.want) {
+			t.Fatalf(`Case %d: got %v, expected %v`, i, got, c.want)
+		}
+		for j := range got {
+			if got[j] != c.want[j] {
+				t.Fatalf(`Case %d: got %v, expected %v`, i, got, c.want)
+			}
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package workers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Gerrit prefixes every JSON response with this to stop it being run as script
+const gerritMagicPrefix string = ")]}'"
+
+// Talks to the Gerrit REST API. Requests are made anonymously unless Username
+// is set, in which case they go to the authenticated /a/ endpoints.
+type GerritClient struct {
+	BaseURL  string
+	Username string
+	// The HTTP password from the Gerrit settings page, not the account password
+	Password string
+	HTTP     *http.Client
+}
+
+// A single patchset of a Gerrit change
+type GerritChange struct {
+	Project  string `json:"project"`
+	Branch   string `json:"branch"`
+	Number   int    `json:"number"`
+	Patchset int    `json:"patchset"`
+	// refs/changes/NN/NNNN/P
+	Ref      string `json:"ref"`
+	Revision string `json:"revision"`
+	// The commit the patchset was uploaded on top of
+	Parent  string `json:"parent"`
+	Subject string `json:"subject"`
+}
+
+type gerritChangeInfo struct {
+	Project         string                        `json:"project"`
+	Branch          string                        `json:"branch"`
+	Number          int                           `json:"_number"`
+	Subject         string                        `json:"subject"`
+	CurrentRevision string                        `json:"current_revision"`
+	Revisions       map[string]gerritRevisionInfo `json:"revisions"`
+}
+
+type gerritRevisionInfo struct {
+	Number int    `json:"_number"`
+	Ref    string `json:"ref"`
+	Commit struct {
+		Parents []struct {
+			Commit string `json:"commit"`
+		} `json:"parents"`
+	} `json:"commit"`
+}
+
+func NewGerritClient(baseURL string) *GerritClient {
+	return &GerritClient{
+		BaseURL: strings.TrimSuffix(baseURL, "/"),
+		HTTP:    &http.Client{Timeout: 60 * time.Second},
+	}
+}
+
+// The current patchset of every open change in topic
+func (c *GerritClient) TopicChanges(topic string) ([]*GerritChange, error) {
+	infos, err := c.query(fmt.Sprintf("topic:\"%s\" status:open", topic), "CURRENT_REVISION", "CURRENT_COMMIT")
+	if err != nil {
+		return nil, err
+	}
+	if len(infos) == 0 {
+		return nil, fmt.Errorf("no open changes found in topic %s", topic)
+	}
+	changes := make([]*GerritChange, 0, len(infos))
+	for _, info := range infos {
+		change, err := info.patchset(0)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, change)
+	}
+	return changes, nil
+}
+
+// Looks up a change given as <number> or <number>/<patchset>. Without a
+// patchset the current one is used.
+func (c *GerritClient) Change(spec string) (*GerritChange, error) {
+	number, patchset, err := parseChangeSpec(spec)
+	if err != nil {
+		return nil, err
+	}
+	options := []string{"CURRENT_REVISION", "CURRENT_COMMIT"}
+	if patchset != 0 {
+		options = []string{"ALL_REVISIONS", "ALL_COMMITS"}
+	}
+	infos, err := c.query(fmt.Sprintf("change:%d", number), options...)
+	if err != nil {
+		return nil, err
+	}
+	if len(infos) == 0 {
+		return nil, fmt.Errorf("change %d not found", number)
+	}
+	return infos[0].patchset(patchset)
+}
+
+func (c *GerritClient) query(q string, options ...string) ([]*gerritChangeInfo, error) {
+	params := url.Values{}
+	params.Set("q", q)
+	for _, o := range options {
+		params.Add("o", o)
+	}
+	endpoint := c.BaseURL + "/changes/"
+	if c.Username != "" {
+		endpoint = c.BaseURL + "/a/changes/"
+	}
+	req, err := http.NewRequestWithContext(runContext, http.MethodGet, endpoint+"?"+params.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.Username != "" {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("gerrit query %q failed with %s: %s", q, resp.Status, strings.TrimSpace(string(body)))
+	}
+	body = []byte(strings.TrimPrefix(string(body), gerritMagicPrefix))
+	infos := make([]*gerritChangeInfo, 0)
+	if err := json.Unmarshal(body, &infos); err != nil {
+		return nil, fmt.Errorf("gerrit query %q: %s", q, err)
+	}
+	return infos, nil
+}
+
+// Picks out a patchset of the change, zero meaning the current one
+func (info *gerritChangeInfo) patchset(number int) (*GerritChange, error) {
+	for revision, rev := range info.Revisions {
+		if (number == 0 && revision != info.CurrentRevision) || (number != 0 && rev.Number != number) {
+			continue
+		}
+		change := &GerritChange{
+			Project:  info.Project,
+			Branch:   info.Branch,
+			Number:   info.Number,
+			Patchset: rev.Number,
+			Ref:      rev.Ref,
+			Revision: revision,
+			Subject:  info.Subject,
+		}
+		if len(rev.Commit.Parents) > 0 {
+			change.Parent = rev.Commit.Parents[0].Commit
+		}
+		return change, nil
+	}
+	if number == 0 {
+		return nil, fmt.Errorf("change %d has no current patchset", info.Number)
+	}
+	return nil, fmt.Errorf("change %d has no patchset %d", info.Number, number)
+}
+
+func parseChangeSpec(spec string) (int, int, error) {
+	parts := strings.Split(spec, "/")
+	if len(parts) > 2 {
+		return 0, 0, fmt.Errorf("bad change %q, expected <number> or <number>/<patchset>", spec)
+	}
+	number, err := strconv.Atoi(parts[0])
+	if err != nil || number <= 0 {
+		return 0, 0, fmt.Errorf("bad change number in %q", spec)
+	}
+	patchset := 0
+	if len(parts) == 2 {
+		patchset, err = strconv.Atoi(parts[1])
+		if err != nil || patchset <= 0 {
+			return 0, 0, fmt.Errorf("bad patchset in %q", spec)
+		}
+	}
+	return number, patchset, nil
+}
+
+// Groups changes by project. Within a project, a change uploaded on top of
+// another comes after it so the stack can be rebased in order; otherwise the
+// changes are ordered by number.
+func GroupChanges(changes []*GerritChange) map[string][]*GerritChange {
+	byProject := make(map[string][]*GerritChange)
+	for _, change := range changes {
+		byProject[change.Project] = append(byProject[change.Project], change)
+	}
+	for project, projectChanges := range byProject {
+		sort.Slice(projectChanges, func(i, j int) bool {
+			return projectChanges[i].Number < projectChanges[j].Number
+		})
+		byProject[project] = orderByParent(projectChanges)
+	}
+	return byProject
+}
+
+func orderByParent(changes []*GerritChange) []*GerritChange {
+	pending := make(map[string]bool)
+	for _, change := range changes {
+		pending[change.Revision] = true
+	}
+	ordered := make([]*GerritChange, 0, len(changes))
+	for len(ordered) < len(changes) {
+		progress := false
+		for _, change := range changes {
+			if !pending[change.Revision] || pending[change.Parent] {
+				continue
+			}
+			ordered = append(ordered, change)
+			pending[change.Revision] = false
+			progress = true
+		}
+		// A cycle cannot come from Gerrit, but never loop forever on bad data
+		if !progress {
+			for _, change := range changes {
+				if pending[change.Revision] {
+					ordered = append(ordered, change)
+					pending[change.Revision] = false
+				}
+			}
+		}
+	}
+	return ordered
+}
+
+// Short form used in messages, e.g. 12345/3
+func (c *GerritChange) String() string {
+	return fmt.Sprintf("%d/%d", c.Number, c.Patchset)
+}
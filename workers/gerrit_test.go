@@ -0,0 +1,107 @@
+package workers_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	workers "yeet/workers"
+)
+
+const topicResponse = `)]}'
+[
+  {"project": "platform/core", "branch": "main", "_number": 102, "subject": "Use the helper",
+   "current_revision": "bbbb",
+   "revisions": {"bbbb": {"_number": 2, "ref": "refs/changes/02/102/2", "commit": {"parents": [{"commit": "aaaa"}]}}}},
+  {"project": "platform/core", "branch": "main", "_number": 101, "subject": "Add a helper",
+   "current_revision": "aaaa",
+   "revisions": {"aaaa": {"_number": 1, "ref": "refs/changes/01/101/1", "commit": {"parents": [{"commit": "0000"}]}}}},
+  {"project": "ui/app", "branch": "main", "_number": 100, "subject": "Show the helper",
+   "current_revision": "cccc",
+   "revisions": {"cccc": {"_number": 4, "ref": "refs/changes/00/100/4", "commit": {"parents": [{"commit": "1111"}]}}}}
+]
+`
+
+const changeResponse = `)]}'
+[
+  {"project": "ui/app", "branch": "main", "_number": 100, "subject": "Show the helper",
+   "current_revision": "cccc",
+   "revisions": {
+     "cccc": {"_number": 4, "ref": "refs/changes/00/100/4", "commit": {"parents": [{"commit": "1111"}]}},
+     "dddd": {"_number": 3, "ref": "refs/changes/00/100/3", "commit": {"parents": [{"commit": "1111"}]}}
+   }}
+]
+`
+
+func newGerritStub(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/changes/" {
+			http.NotFound(w, r)
+			return
+		}
+		switch r.URL.Query().Get("q") {
+		case `topic:"helper" status:open`:
+			fmt.Fprint(w, topicResponse)
+		case "change:100":
+			fmt.Fprint(w, changeResponse)
+		case "change:999":
+			fmt.Fprint(w, ")]}'\n[]\n")
+		default:
+			http.Error(w, "bad query", http.StatusBadRequest)
+		}
+	}))
+}
+
+func TestGerritTopicChanges(t *testing.T) {
+	server := newGerritStub(t)
+	defer server.Close()
+	client := workers.NewGerritClient(server.URL + "/")
+	changes, err := client.TopicChanges("helper")
+	if err != nil {
+		t.Fatalf(`Topic query failed: %s`, err)
+	}
+	if len(changes) != 3 {
+		t.Fatalf(`Got %d changes, expected 3`, len(changes))
+	}
+	byProject := workers.GroupChanges(changes)
+	core := byProject["platform/core"]
+	if len(core) != 2 || core[0].Number != 101 || core[1].Number != 102 {
+		t.Fatalf(`platform/core changes are not in stack order: %v`, core)
+	}
+	if core[1].Ref != "refs/changes/02/102/2" || core[1].Parent != "aaaa" {
+		t.Fatalf(`Got ref %s with parent %s for change 102`, core[1].Ref, core[1].Parent)
+	}
+	if len(byProject["ui/app"]) != 1 {
+		t.Fatalf(`Expected 1 change in ui/app, got %d`, len(byProject["ui/app"]))
+	}
+	if _, err := client.TopicChanges("missing"); err == nil {
+		t.Fatalf(`Expected an error for a failed query`)
+	}
+}
+
+func TestGerritChange(t *testing.T) {
+	server := newGerritStub(t)
+	defer server.Close()
+	client := workers.NewGerritClient(server.URL)
+	cases := []struct {
+		spec string
+		want string
+	}{
+		{"100", "refs/changes/00/100/4"},
+		{"100/3", "refs/changes/00/100/3"},
+	}
+	for _, c := range cases {
+		change, err := client.Change(c.spec)
+		if err != nil {
+			t.Fatalf(`Change %s failed: %s`, c.spec, err)
+		}
+		if change.Ref != c.want {
+			t.Fatalf(`Change %s: got %s, expected %s`, c.spec, change.Ref, c.want)
+		}
+	}
+	for _, spec := range []string{"100/7", "999", "abc", "100/2/1"} {
+		if _, err := client.Change(spec); err == nil {
+			t.Fatalf(`Expected an error for change %s`, spec)
+		}
+	}
+}
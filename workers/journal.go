@@ -0,0 +1,113 @@
+package workers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+var JournalFilename string = "journal.json"
+
+// Records the state of every repo before a take so that it can be undone
+type Journal struct {
+	Target  string          `json:"target"`
+	Time    time.Time       `json:"time"`
+	Entries []*JournalEntry `json:"entries"`
+	path    string
+	mu      sync.Mutex
+}
+
+// Exported struct (via json)
+type JournalEntry struct {
+	Name     string `json:"name"`
+	Path     string `json:"path"`
+	Branch   string `json:"branch"`
+	HEAD     string `json:"head"`
+	StashRef string `json:"stashref,omitempty"`
+	// Local branches that the take may rewrite, mapped to their original hash
+	Branches map[string]string `json:"branches,omitempty"`
+	// Set while a conflict is left open by take --keep-conflicts
+	Pending *PendingTake `json:"pending,omitempty"`
+}
+
+// What a take still has to do in a repo once its conflict is resolved
+type PendingTake struct {
+	Targets  []string `json:"targets,omitempty"`
+	Strategy string   `json:"strategy"`
+	// For Gerrit takes, the branch and the changes after the one that stopped
+	Branch  string          `json:"branch,omitempty"`
+	Changes []*GerritChange `json:"changes,omitempty"`
+	// For cherry-picks, the branch's tip before the pick that stopped
+	Head string `json:"head,omitempty"`
+}
+
+func NewJournal(path string, target string) *Journal {
+	return &Journal{Target: target, Time: time.Now(), Entries: make([]*JournalEntry, 0), path: path}
+}
+
+func LoadJournal(path string) (*Journal, error) {
+	file, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	journal := Journal{path: path}
+	if err := json.Unmarshal(file, &journal); err != nil {
+		return nil, err
+	}
+	return &journal, nil
+}
+
+// Adds an entry and writes the journal to disk straight away, so that an
+// interrupted take can still be undone
+func (j *Journal) Record(entry *JournalEntry) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.Entries = append(j.Entries, entry)
+	return j.save()
+}
+
+func (j *Journal) SetStash(entry *JournalEntry, ref string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	entry.StashRef = ref
+	return j.save()
+}
+
+func (j *Journal) SetPending(entry *JournalEntry, pending *PendingTake) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	entry.Pending = pending
+	return j.save()
+}
+
+// The entries of repos with a conflict left open
+func (j *Journal) PendingEntries() []*JournalEntry {
+	pending := make([]*JournalEntry, 0)
+	for _, entry := range j.Entries {
+		if entry.Pending != nil {
+			pending = append(pending, entry)
+		}
+	}
+	return pending
+}
+
+func (j *Journal) Save() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.save()
+}
+
+func (j *Journal) Remove() error {
+	return os.Remove(j.path)
+}
+
+func (j *Journal) save() error {
+	if err := os.MkdirAll(filepath.Dir(j.path), 0755); err != nil {
+		return err
+	}
+	jsontext, _ := json.MarshalIndent(j, "", "\t")
+	return ioutil.WriteFile(j.path, jsontext, 0644)
+}
@@ -0,0 +1,229 @@
+package workers
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// A manifest element with its attributes, kept in document order
+type manifestNode struct {
+	XMLName xml.Name
+	Attrs   []xml.Attr `xml:",any,attr"`
+	// Groups given by the <include> the node was read through
+	includeGroups []string
+}
+
+type manifestFile struct {
+	Nodes []manifestNode `xml:",any"`
+}
+
+func (n *manifestNode) attr(name string) string {
+	for _, a := range n.Attrs {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+type manifestRemote struct {
+	name     string
+	alias    string
+	fetch    string
+	revision string
+}
+
+type manifestDefault struct {
+	remote     string
+	revision   string
+	destBranch string
+	upstream   string
+}
+
+// Reads the repo tool's manifest for the workspace at codeDir, following the
+// same rules as `repo`: .repo/manifest.xml and its includes first, then every
+// file in .repo/local_manifests. Returns the projects sorted by path.
+func ParseManifest(codeDir string) ([]*RepoInfo, error) {
+	repoDir := filepath.Join(codeDir, ".repo")
+	nodes, err := readManifestNodes(filepath.Join(repoDir, "manifest.xml"), filepath.Join(repoDir, "manifests"), nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	localDir := filepath.Join(repoDir, "local_manifests")
+	localFiles, _ := filepath.Glob(filepath.Join(localDir, "*.xml"))
+	sort.Strings(localFiles)
+	// The older single local manifest is still read by repo
+	if _, err := os.Stat(filepath.Join(repoDir, "local_manifest.xml")); err == nil {
+		localFiles = append([]string{filepath.Join(repoDir, "local_manifest.xml")}, localFiles...)
+	}
+	for _, localFile := range localFiles {
+		localNodes, err := readManifestNodes(localFile, localDir, nil, 0)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, localNodes...)
+	}
+
+	remotes := make(map[string]*manifestRemote)
+	for _, node := range nodes {
+		if node.XMLName.Local != "remote" {
+			continue
+		}
+		r := &manifestRemote{node.attr("name"), node.attr("alias"), node.attr("fetch"), node.attr("revision")}
+		remotes[r.name] = r
+	}
+	def := &manifestDefault{}
+	for _, node := range nodes {
+		if node.XMLName.Local != "default" {
+			continue
+		}
+		def = &manifestDefault{node.attr("remote"), node.attr("revision"), node.attr("dest-branch"), node.attr("upstream")}
+	}
+
+	manifestURL := readManifestURL(repoDir)
+	projects := make([]*RepoInfo, 0)
+	for _, node := range nodes {
+		switch node.XMLName.Local {
+		case "project":
+			info, err := newProjectInfo(codeDir, &node, remotes, def, manifestURL)
+			if err != nil {
+				return nil, err
+			}
+			projects = append(projects, info)
+		case "remove-project":
+			name := node.attr("name")
+			relPath := node.attr("path")
+			kept := projects[:0]
+			for _, p := range projects {
+				if (name == "" || p.Name == name) && (relPath == "" || p.RelPath == relPath) {
+					continue
+				}
+				kept = append(kept, p)
+			}
+			projects = kept
+		}
+	}
+	sort.Slice(projects, func(i, j int) bool {
+		return projects[i].RelPath < projects[j].RelPath
+	})
+	return projects, nil
+}
+
+// Reads a manifest file and splices in the nodes of any files it includes
+func readManifestNodes(file string, includeDir string, groups []string, depth int) ([]manifestNode, error) {
+	if depth > 16 {
+		return nil, fmt.Errorf("%s: too many nested includes", file)
+	}
+	text, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var manifest manifestFile
+	if err := xml.Unmarshal(text, &manifest); err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+	nodes := make([]manifestNode, 0, len(manifest.Nodes))
+	for _, node := range manifest.Nodes {
+		node.includeGroups = groups
+		if node.XMLName.Local != "include" {
+			nodes = append(nodes, node)
+			continue
+		}
+		name := node.attr("name")
+		if name == "" {
+			return nil, fmt.Errorf("%s: <include> without a name", file)
+		}
+		includeGroups := append(append([]string{}, groups...), splitGroups(node.attr("groups"))...)
+		included, err := readManifestNodes(filepath.Join(includeDir, name), includeDir, includeGroups, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, included...)
+	}
+	return nodes, nil
+}
+
+func newProjectInfo(codeDir string, node *manifestNode, remotes map[string]*manifestRemote, def *manifestDefault, manifestURL string) (*RepoInfo, error) {
+	name := node.attr("name")
+	if name == "" {
+		return nil, fmt.Errorf("<project> without a name")
+	}
+	relPath := node.attr("path")
+	if relPath == "" {
+		relPath = name
+	}
+	info := &RepoInfo{
+		Path:       filepath.Join(codeDir, filepath.FromSlash(relPath)),
+		Name:       name,
+		RelPath:    relPath,
+		Revision:   firstNonEmpty(node.attr("revision"), def.revision),
+		DestBranch: firstNonEmpty(node.attr("dest-branch"), def.destBranch),
+		Upstream:   firstNonEmpty(node.attr("upstream"), def.upstream),
+		Groups:     append(splitGroups(node.attr("groups")), node.includeGroups...),
+	}
+	remoteName := firstNonEmpty(node.attr("remote"), def.remote)
+	remote, ok := remotes[remoteName]
+	if !ok {
+		return nil, fmt.Errorf("project %s uses undefined remote %q", name, remoteName)
+	}
+	// Checkouts name their remote after the alias when there is one
+	info.Remote = firstNonEmpty(remote.alias, remote.name)
+	info.RemoteURL = resolveFetchURL(remote.fetch, manifestURL) + "/" + name
+	if node.attr("revision") == "" && remote.revision != "" {
+		info.Revision = remote.revision
+	}
+	return info, nil
+}
+
+// Fetch URLs such as ".." are relative to the URL the manifest was cloned from
+func resolveFetchURL(fetch string, manifestURL string) string {
+	fetch = strings.TrimSuffix(fetch, "/")
+	if !strings.HasPrefix(fetch, ".") || manifestURL == "" {
+		return fetch
+	}
+	base, err := url.Parse(strings.TrimSuffix(manifestURL, "/"))
+	if err != nil || base.Scheme == "" {
+		return fetch
+	}
+	base.Path = path.Join(base.Path, fetch)
+	return base.String()
+}
+
+// The URL the manifest repo was cloned from, or an empty string if unknown
+func readManifestURL(repoDir string) string {
+	for _, dir := range []string{"manifests.git", "manifests"} {
+		args := []string{"config", "--get", "remote.origin.url"}
+		cmd := GitCommand{args, filepath.Join(repoDir, dir)}
+		if _, err := os.Stat(cmd.Path); err != nil {
+			continue
+		}
+		result := cmd.Run()
+		if result.Passed && len(result.Output) == 1 {
+			return result.Output[0]
+		}
+	}
+	return ""
+}
+
+// Groups are separated by commas or whitespace
+func splitGroups(groups string) []string {
+	return strings.FieldsFunc(groups, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n'
+	})
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,87 @@
+package workers_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	workers "yeet/workers"
+)
+
+func writeManifestFiles(t *testing.T, root string, files map[string]string) {
+	for name, text := range files {
+		p := filepath.Join(root, ".repo", name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestParseManifest(t *testing.T) {
+	root := t.TempDir()
+	writeManifestFiles(t, root, map[string]string{
+		"manifest.xml": `<manifest><include name="default.xml"/></manifest>`,
+		"manifests/default.xml": `<manifest>
+  <remote name="aosp" fetch="https://example.com/aosp" revision="stable"/>
+  <remote name="corp" alias="origin" fetch="https://example.com/corp/"/>
+  <default remote="corp" revision="main" dest-branch="develop"/>
+  <project name="platform/core" path="core" groups="platform"/>
+  <project name="ui/app" groups="ui,notdefault" revision="release-1"/>
+  <project name="external/lib" remote="aosp"/>
+  <include name="extra.xml" groups="extra"/>
+</manifest>`,
+		"manifests/extra.xml": `<manifest>
+  <project name="tools/build" path="build"/>
+</manifest>`,
+		"local_manifests/local.xml": `<manifest>
+  <remove-project name="ui/app"/>
+  <project name="ui/app" path="ui" dest-branch="ui-next"/>
+</manifest>`,
+	})
+
+	projects, err := workers.ParseManifest(root)
+	if err != nil {
+		t.Fatalf(`Error parsing manifest: %v`, err)
+	}
+	byName := make(map[string]*workers.RepoInfo)
+	for _, p := range projects {
+		byName[p.Name] = p
+	}
+	if len(projects) != 4 {
+		t.Fatalf(`Expected 4 projects, got %d`, len(projects))
+	}
+
+	core := byName["platform/core"]
+	if core.Path != filepath.Join(root, "core") || core.RelPath != "core" {
+		t.Fatalf(`Wrong path for platform/core: %+v`, core)
+	}
+	if core.Remote != "origin" || core.RemoteURL != "https://example.com/corp/platform/core" {
+		t.Fatalf(`Remote alias not applied for platform/core: %+v`, core)
+	}
+	if core.Revision != "main" || core.DestBranch != "develop" || !reflect.DeepEqual(core.Groups, []string{"platform"}) {
+		t.Fatalf(`Defaults not applied for platform/core: %+v`, core)
+	}
+
+	lib := byName["external/lib"]
+	if lib.Remote != "aosp" || lib.Revision != "stable" || lib.RelPath != "external/lib" {
+		t.Fatalf(`Remote revision not applied for external/lib: %+v`, lib)
+	}
+
+	build := byName["tools/build"]
+	if build == nil || !reflect.DeepEqual(build.Groups, []string{"extra"}) {
+		t.Fatalf(`Include groups not applied for tools/build: %+v`, build)
+	}
+
+	// The local manifest replaced the project from the main manifest
+	app := byName["ui/app"]
+	if app.RelPath != "ui" || app.Revision != "main" || app.DestBranch != "ui-next" || len(app.Groups) != 0 {
+		t.Fatalf(`Local manifest not applied for ui/app: %+v`, app)
+	}
+	if projects[0].RelPath != "build" || projects[3].RelPath != "ui" {
+		t.Fatalf(`Projects are not sorted by path`)
+	}
+}
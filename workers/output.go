@@ -0,0 +1,87 @@
+package workers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// The format results are printed in. Set via the --output,-o flag
+var Output string = "text"
+
+var outputFormats = []string{"text", "json", "ndjson"}
+
+// Anything a command reports per repo
+type Record interface {
+	Format() string
+}
+
+// Prints records as colored text, one JSON object per line (ndjson), or a
+// single JSON array once the command is done (json)
+type Reporter struct {
+	format  string
+	out     io.Writer
+	records []Record
+	// Counted so the command can exit with a code matching its results
+	failures  int
+	conflicts int
+}
+
+func NewReporter() *Reporter {
+	return &Reporter{format: Output, out: os.Stdout, records: make([]Record, 0)}
+}
+
+func (r *Reporter) Report(record Record) {
+	switch rec := record.(type) {
+	case *WorkFlowResult:
+		if rec.Status == FAILED || rec.Status == INTRPT {
+			r.failures++
+		} else if rec.Status == CNFLCT {
+			r.conflicts++
+		}
+	case *SearchResult:
+		if rec.Error != "" {
+			r.failures++
+		}
+	case *ExecResult:
+		if rec.Failed() {
+			r.failures++
+		}
+	}
+	switch r.format {
+	case "json":
+		r.records = append(r.records, record)
+	case "ndjson":
+		line, _ := json.Marshal(record)
+		fmt.Fprintln(r.out, string(line))
+	default:
+		fmt.Fprint(r.out, record.Format())
+	}
+}
+
+// Writes out anything held back until the end
+func (r *Reporter) Close() {
+	if r.format == "json" {
+		text, _ := json.MarshalIndent(r.records, "", "\t")
+		fmt.Fprintln(r.out, string(text))
+	}
+}
+
+// Summarises the reported results, nil if everything passed
+func (r *Reporter) Err() error {
+	if r.failures == 0 && r.conflicts == 0 {
+		return nil
+	}
+	return &ResultError{r.failures, r.conflicts}
+}
+
+// Prints progress messages. They go to stderr when the output is JSON so that
+// stdout can be piped straight into other tools.
+func logf(format string, a ...interface{}) {
+	if Output == "text" {
+		fmt.Printf(format, a...)
+	} else {
+		fmt.Fprintf(os.Stderr, format, a...)
+	}
+}
@@ -0,0 +1,114 @@
+package workers_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+	workers "yeet/workers"
+)
+
+func TestWorkFlowResultJSON(t *testing.T) {
+	result := workers.WorkFlowResult{
+		RepoName:  "test",
+		Path:      "/src/test",
+		Status:    workers.CNFLCT,
+		OldBranch: "main",
+		NewBranch: "feature123",
+		Message:   "[main]->[feature123]: [abcd]",
+	}
+	text, err := json.Marshal(&result)
+	if err != nil {
+		t.Fatalf(`Error marshalling result: %v`, err)
+	}
+	var record map[string]interface{}
+	if err := json.Unmarshal(text, &record); err != nil {
+		t.Fatalf(`Error unmarshalling result: %v`, err)
+	}
+	if record["status"] != "CNFLCT" || record["code"] != float64(workers.CNFLCT.Code) {
+		t.Fatalf(`Status not marshalled: %s`, text)
+	}
+	if record["name"] != "test" || record["new_branch"] != "feature123" {
+		t.Fatalf(`Fields not marshalled: %s`, text)
+	}
+	if _, ok := record["Message"]; ok {
+		t.Fatalf(`Message should only be printed as text: %s`, text)
+	}
+}
+
+func TestConflictOutput(t *testing.T) {
+	result := workers.WorkFlowResult{
+		RepoName: "test",
+		Status:   workers.CNFLCT,
+		Message:  "[main]->[feature123]: [abcd]",
+		Conflict: &workers.ConflictInfo{
+			Commit:      "0123456789abcdef0123456789abcdef01234567",
+			Subject:     "Change the widget",
+			Author:      "A Coworker",
+			AuthorEmail: "coworker@example.com",
+			Onto:        "origin/main",
+			OntoSHA:     "fedcba9876543210fedcba9876543210fedcba98",
+			Paths:       []string{"src/widget.c", "include/widget.h"},
+		},
+	}
+	text := result.Format()
+	for _, want := range []string{"01234567 Change the widget by A Coworker <coworker@example.com>", "onto origin/main [fedcba98]", "conflicts in src/widget.c, include/widget.h"} {
+		if !strings.Contains(text, want) {
+			t.Fatalf(`Text output %q is missing %q`, text, want)
+		}
+	}
+	record, err := json.Marshal(&result)
+	if err != nil {
+		t.Fatalf(`Error marshalling result: %v`, err)
+	}
+	var parsed struct {
+		Conflict workers.ConflictInfo `json:"conflict"`
+	}
+	if err := json.Unmarshal(record, &parsed); err != nil {
+		t.Fatalf(`Error unmarshalling result: %v`, err)
+	}
+	if parsed.Conflict.Commit != result.Conflict.Commit || len(parsed.Conflict.Paths) != 2 {
+		t.Fatalf(`Conflict not marshalled: %s`, record)
+	}
+}
+
+func TestOfflineOutput(t *testing.T) {
+	fetchedAt := time.Now().Add(-3 * time.Hour)
+	result := workers.SearchResult{RepoName: "test", Target: "remotes/origin/feature123", Cached: true, Offline: true, FetchedAt: &fetchedAt}
+	if text := result.Format(); !strings.Contains(text, "(offline, fetched 3h ago)") {
+		t.Fatalf(`Text output %q does not say how old the fetch is`, text)
+	}
+	result.Offline = false
+	if text := result.Format(); !strings.Contains(text, "(fetched 3h ago)") {
+		t.Fatalf(`Text output %q should only say how old the fetch is`, text)
+	}
+	result.FetchedAt = nil
+	if text := result.Format(); !strings.Contains(text, "(last fetch unknown)") {
+		t.Fatalf(`Text output %q does not say the fetch time is unknown`, text)
+	}
+	record, err := json.Marshal(&workers.WorkFlowResult{RepoName: "test", Status: workers.CURRNT, Offline: true, FetchedAt: &fetchedAt})
+	if err != nil {
+		t.Fatalf(`Error marshalling result: %v`, err)
+	}
+	if !strings.Contains(string(record), `"offline":true`) || !strings.Contains(string(record), `"fetched_at"`) {
+		t.Fatalf(`Offline fields not marshalled: %s`, record)
+	}
+}
+
+func TestExecOutput(t *testing.T) {
+	result := workers.ExecResult{RepoName: "test", Path: "/src/test", ExitCode: 2, Output: []string{"first", "second"}}
+	text := result.Format()
+	if !strings.Contains(text, "test") || !strings.Contains(text, "  first\n  second\n") {
+		t.Fatalf(`Grouped output %q is missing the repo's lines`, text)
+	}
+	if !result.Failed() {
+		t.Fatalf(`A non-zero exit code should count as a failure`)
+	}
+	record, err := json.Marshal(&result)
+	if err != nil {
+		t.Fatalf(`Error marshalling result: %v`, err)
+	}
+	if !strings.Contains(string(record), `"exit_code":2`) || !strings.Contains(string(record), `"output":["first","second"]`) {
+		t.Fatalf(`Exec result not marshalled: %s`, record)
+	}
+}
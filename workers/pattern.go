@@ -0,0 +1,49 @@
+package workers
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Compiles the branch pattern given to yeet find. Unless regex is set it is a
+// glob matching the whole branch name, where * and ? also match /, so that
+// *login* finds feature/login. A name without wildcards matches only itself.
+func BranchPattern(pattern string, regex bool) (*regexp.Regexp, error) {
+	if regex {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("bad regex %q: %s", pattern, err)
+		}
+		return re, nil
+	}
+	var sb strings.Builder
+	sb.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			sb.WriteString(".*")
+		case '?':
+			sb.WriteString(".")
+		case '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("bad glob %q: unterminated [", pattern)
+			}
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			sb.WriteString("[" + class + "]")
+			i += end + 1
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	sb.WriteString("$")
+	re, err := regexp.Compile(sb.String())
+	if err != nil {
+		return nil, fmt.Errorf("bad glob %q: %s", pattern, err)
+	}
+	return re, nil
+}
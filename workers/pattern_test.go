@@ -0,0 +1,45 @@
+package workers_test
+
+import (
+	"testing"
+	workers "yeet/workers"
+)
+
+func TestBranchPattern(t *testing.T) {
+	cases := []struct {
+		pattern string
+		regex   bool
+		branch  string
+		want    bool
+	}{
+		{"feature123", false, "feature123", true},
+		{"feature123", false, "feature1234", false},
+		{"feature/*login*", false, "feature/fix-login-page", true},
+		{"feature/*login*", false, "bugfix/login", false},
+		{"*login*", false, "feature/team/login", true},
+		{"release-1.?", false, "release-1.2", true},
+		{"release-1.?", false, "release-1x22", false},
+		{"v[0-9]*", false, "v2-cleanup", true},
+		{"v[!0-9]*", false, "v2-cleanup", false},
+		{"log.n", false, "login", false},
+		{"log(in|out)$", true, "feature/logout", true},
+		{"^feature/", true, "bugfix/feature/x", false},
+	}
+	for _, c := range cases {
+		re, err := workers.BranchPattern(c.pattern, c.regex)
+		if err != nil {
+			t.Fatalf(`Pattern %q failed to compile: %s`, c.pattern, err)
+		}
+		if got := re.MatchString(c.branch); got != c.want {
+			t.Fatalf(`Pattern %q on %q: got %v, expected %v`, c.pattern, c.branch, got, c.want)
+		}
+	}
+	for _, bad := range []struct {
+		pattern string
+		regex   bool
+	}{{"feature/[abc", false}, {"feature/(", true}} {
+		if _, err := workers.BranchPattern(bad.pattern, bad.regex); err == nil {
+			t.Fatalf(`Expected an error for pattern %q`, bad.pattern)
+		}
+	}
+}
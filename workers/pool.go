@@ -0,0 +1,23 @@
+package workers
+
+// Bounds how many repos are worked on at once. Each repo runs several git
+// processes, so starting one goroutine per repo is not enough of a limit.
+type WorkerPool struct {
+	sem chan struct{}
+}
+
+func NewWorkerPool(jobs int) *WorkerPool {
+	if jobs < 1 {
+		jobs = 1
+	}
+	return &WorkerPool{make(chan struct{}, jobs)}
+}
+
+// Runs fn in a new goroutine as soon as a job slot is free
+func (p *WorkerPool) Go(fn func()) {
+	go func() {
+		p.sem <- struct{}{}
+		defer func() { <-p.sem }()
+		fn()
+	}()
+}
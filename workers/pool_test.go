@@ -0,0 +1,36 @@
+package workers_test
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	workers "yeet/workers"
+)
+
+func TestWorkerPoolLimit(t *testing.T) {
+	const jobs = 3
+	pool := workers.NewWorkerPool(jobs)
+	var running int32
+	var peak int32
+	wg := sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		pool.Go(func() {
+			defer wg.Done()
+			n := atomic.AddInt32(&running, 1)
+			for {
+				p := atomic.LoadInt32(&peak)
+				if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+			atomic.AddInt32(&running, -1)
+		})
+	}
+	wg.Wait()
+	if peak > jobs {
+		t.Fatalf(`Pool ran %d jobs at once, limit is %d`, peak, jobs)
+	}
+}
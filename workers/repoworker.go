@@ -1,13 +1,56 @@
 package workers
 
 import (
+	"context"
 	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
 )
 
 type RepoWorker struct {
 	RepoInfo *RepoInfo
 	Branch   string
 	Remotes  []string
+	// Set when the last rebase, merge or cherry-pick stopped on a conflict
+	Conflict *ConflictInfo
+	// Leave conflicted operations in progress instead of aborting them
+	KeepConflicts bool
+	// Where the branch was before a cherry-pick left stopped by KeepConflicts,
+	// as aborting it only goes back to what it was being picked onto
+	PickedFrom string
+	// Set when the remote was not fetched and its cached refs were used, along
+	// with when it was last fetched, zero if unknown
+	Cached    bool
+	LastFetch time.Time
+}
+
+// Every stash made by yeet is labelled "yeet: <branch> before <action>"
+const StashPrefix string = "yeet: "
+
+var stashLabel = regexp.MustCompile(StashPrefix + `(\S+) before .+$`)
+
+// The ways a topic can be brought onto its base branch. Set via the --strategy
+// flag of take or the config file
+const (
+	StrategyRebase     string = "rebase"
+	StrategyMerge      string = "merge"
+	StrategyFFOnly     string = "ff-only"
+	StrategyCherryPick string = "cherry-pick"
+)
+
+var Strategies = []string{StrategyRebase, StrategyMerge, StrategyFFOnly, StrategyCherryPick}
+
+// The longest the cleanup after an interrupt may take
+var CleanupTimeout time.Duration = 30 * time.Second
+
+type StashEntry struct {
+	Ref     string
+	Hash    string
+	Branch  string
+	Message string
 }
 
 type RepoWorkerInitializer struct {
@@ -23,7 +66,7 @@ func (init *RepoWorkerInitializer) NewRepoWorker() (*RepoWorker, error) {
 	if err != nil {
 		return nil, fmt.Errorf("(%s): %s", init.RepoInfo.Name, err)
 	}
-	return &RepoWorker{init.RepoInfo, branch, remotes}, nil
+	return &RepoWorker{RepoInfo: init.RepoInfo, Branch: branch, Remotes: remotes}, nil
 }
 
 func (init *RepoWorkerInitializer) CurrentBranch() (string, error) {
@@ -81,14 +124,63 @@ func (w *RepoWorker) RevParseUpstream(branch string) (string, error) {
 	return "", fmt.Errorf("%s failed with ErrorCode %d", cmd.Print(), result.ErrorCode)
 }
 
-func (w *RepoWorker) Stash() error {
-	args := []string{"stash", "--include-untracked"}
+// Stashes all changes under the given message and returns the hash of the new
+// stash, or an empty string if there was nothing to stash
+func (w *RepoWorker) Stash(message string) (string, error) {
+	prevStash := w.StashRef()
+	args := []string{"stash", "push", "--include-untracked", "--message", message}
 	cmd := GitCommand{args, w.RepoInfo.Path}
 	result := cmd.Run()
-	if result.Passed {
-		return nil
+	if !result.Passed {
+		return "", fmt.Errorf("%s failed with ErrorCode %d", cmd.Print(), result.ErrorCode)
 	}
-	return fmt.Errorf("%s failed with ErrorCode %d", cmd.Print(), result.ErrorCode)
+	if stash := w.StashRef(); stash != prevStash {
+		return stash, nil
+	}
+	return "", nil
+}
+
+// Lists the stashes made by yeet, newest first
+func (w *RepoWorker) YeetStashes() ([]*StashEntry, error) {
+	args := []string{"stash", "list", "--format=%gd%x09%H%x09%gs"}
+	cmd := GitCommand{args, w.RepoInfo.Path}
+	result := cmd.Run()
+	if !result.Passed {
+		return nil, fmt.Errorf("%s failed with ErrorCode %d", cmd.Print(), result.ErrorCode)
+	}
+	stashes := make([]*StashEntry, 0)
+	for _, line := range result.Output {
+		chunks := strings.SplitN(line, "\t", 3)
+		if len(chunks) != 3 {
+			continue
+		}
+		match := stashLabel.FindStringSubmatch(chunks[2])
+		if match == nil {
+			continue
+		}
+		stashes = append(stashes, &StashEntry{chunks[0], chunks[1], match[1], match[0]})
+	}
+	return stashes, nil
+}
+
+func (w *RepoWorker) StashPop(ref string) error {
+	args := []string{"stash", "pop", ref}
+	cmd := GitCommand{args, w.RepoInfo.Path}
+	result := cmd.Run()
+	if !result.Passed {
+		return fmt.Errorf("%s failed with ErrorCode %d: %v", cmd.Print(), result.ErrorCode, result.Output)
+	}
+	return nil
+}
+
+func (w *RepoWorker) StashDrop(ref string) error {
+	args := []string{"stash", "drop", ref}
+	cmd := GitCommand{args, w.RepoInfo.Path}
+	result := cmd.Run()
+	if !result.Passed {
+		return fmt.Errorf("%s failed with ErrorCode %d: %v", cmd.Print(), result.ErrorCode, result.Output)
+	}
+	return nil
 }
 
 func (w *RepoWorker) BranchList() ([]string, error) {
@@ -101,8 +193,39 @@ func (w *RepoWorker) BranchList() ([]string, error) {
 	return nil, fmt.Errorf("%s failed with ErrorCode %d", cmd.Print(), result.ErrorCode)
 }
 
+// A local or remote-tracking branch and the commit at its tip
+type BranchRef struct {
+	// Full name, e.g. refs/remotes/origin/feature
+	Ref    string
+	SHA    string
+	Author string
+	Date   time.Time
+}
+
+// The branches under the given ref prefixes, e.g. refs/heads
+func (w *RepoWorker) BranchRefs(prefixes ...string) ([]*BranchRef, error) {
+	args := append([]string{"for-each-ref", "--format=%(refname)\t%(objectname)\t%(authorname)\t%(committerdate:iso-strict)"}, prefixes...)
+	cmd := GitCommand{args, w.RepoInfo.Path}
+	result := cmd.Run()
+	if !result.Passed {
+		return nil, fmt.Errorf("%s failed with ErrorCode %d: %v", cmd.Print(), result.ErrorCode, result.Output)
+	}
+	refs := make([]*BranchRef, 0, len(result.Output))
+	for _, line := range result.Output {
+		fields := strings.Split(line, "\t")
+		if len(fields) != 4 {
+			continue
+		}
+		ref := &BranchRef{Ref: fields[0], SHA: fields[1], Author: fields[2]}
+		ref.Date, _ = time.Parse(time.RFC3339, fields[3])
+		refs = append(refs, ref)
+	}
+	return refs, nil
+}
+
+// The porcelain v2 status, read by ParseStatus
 func (w *RepoWorker) StatusBranch() ([]string, error) {
-	args := []string{"status", "-b", "--porcelain"}
+	args := []string{"status", "--branch", "--porcelain=v2"}
 	cmd := GitCommand{args, w.RepoInfo.Path}
 	result := cmd.Run()
 	if result.Passed {
@@ -111,14 +234,194 @@ func (w *RepoWorker) StatusBranch() ([]string, error) {
 	return nil, fmt.Errorf("%s failed with ErrorCode %d", cmd.Print(), result.ErrorCode)
 }
 
+// Checks for staged, unstaged or untracked changes
+func (w *RepoWorker) IsDirty() (bool, error) {
+	lines, err := w.StatusBranch()
+	if err != nil {
+		return false, err
+	}
+	return ParseStatus(lines).Dirty(), nil
+}
+
+// Counts the commits only in left and only in right
+func (w *RepoWorker) AheadBehind(left string, right string) (int, int, error) {
+	args := []string{"rev-list", "--left-right", "--count", left + "..." + right}
+	cmd := GitCommand{args, w.RepoInfo.Path}
+	result := cmd.Run()
+	if result.Passed && len(result.Output) == 1 {
+		var ahead, behind int
+		if _, err := fmt.Sscanf(result.Output[0], "%d %d", &ahead, &behind); err == nil {
+			return ahead, behind, nil
+		}
+	}
+	return 0, 0, fmt.Errorf("%s failed with ErrorCode %d: %v", cmd.Print(), result.ErrorCode, result.Output)
+}
+
+// Counts the commits on branch that are on no remote-tracking branch of remote
+func (w *RepoWorker) Unpushed(branch string, remote string) (int, error) {
+	args := []string{"rev-list", "--count", branch, "--not", "--remotes=" + remote}
+	cmd := GitCommand{args, w.RepoInfo.Path}
+	result := cmd.Run()
+	if result.Passed && len(result.Output) == 1 {
+		var count int
+		if _, err := fmt.Sscanf(result.Output[0], "%d", &count); err == nil {
+			return count, nil
+		}
+	}
+	return 0, fmt.Errorf("%s failed with ErrorCode %d: %v", cmd.Print(), result.ErrorCode, result.Output)
+}
+
+// When the repo was last fetched, from the time FETCH_HEAD was written. A repo
+// that has only ever been cloned has no FETCH_HEAD.
+func (w *RepoWorker) LastFetched() (time.Time, error) {
+	p, err := w.gitPath(runContext, "FETCH_HEAD")
+	if err != nil {
+		return time.Time{}, err
+	}
+	fi, err := os.Stat(p)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return fi.ModTime(), nil
+}
+
+func (w *RepoWorker) StashCount() (int, error) {
+	args := []string{"stash", "list"}
+	cmd := GitCommand{args, w.RepoInfo.Path}
+	result := cmd.Run()
+	if !result.Passed {
+		return 0, fmt.Errorf("%s failed with ErrorCode %d", cmd.Print(), result.ErrorCode)
+	}
+	return len(result.Output), nil
+}
+
 func (w *RepoWorker) Rebase(targetBranch string) (bool, error) {
-	args := []string{"rebase", targetBranch}
+	return w.integrate([]string{"rebase", targetBranch}, "REBASE_HEAD", targetBranch)
+}
+
+// Brings the current branch onto onto with the given strategy. Returns false if
+// there were conflicts, after aborting. A branch that cannot be fast-forwarded
+// is an error.
+func (w *RepoWorker) Integrate(strategy string, onto string) (bool, error) {
+	switch strategy {
+	case StrategyMerge:
+		return w.mergeDetached(onto)
+	case StrategyFFOnly:
+		args := []string{"merge", "--ff-only", onto}
+		cmd := GitCommand{args, w.RepoInfo.Path}
+		result := cmd.Run()
+		if !result.Passed {
+			return false, fmt.Errorf("%s cannot be fast-forwarded to %s", w.Branch, onto)
+		}
+		return true, nil
+	case StrategyCherryPick:
+		return w.cherryPickOnto(onto)
+	}
+	return w.Rebase(onto)
+}
+
+// Merges onto into the current branch, for bringing up a base branch
+func (w *RepoWorker) Merge(onto string) (bool, error) {
+	// The branch's own tip is what clashes with onto, not MERGE_HEAD which is onto itself
+	return w.integrate([]string{"merge", "--no-edit", onto}, "HEAD", onto)
+}
+
+// Builds a throwaway merge of HEAD into onto on a detached HEAD, so that the
+// topic branch and the hashes of its commits stay exactly as the coworker
+// pushed them, as they will when it lands through a merge queue
+func (w *RepoWorker) mergeDetached(onto string) (bool, error) {
+	prevBranch := w.Branch
+	head, err := w.RevParseFull("HEAD")
+	if err != nil {
+		return false, err
+	}
+	args := []string{"checkout", "--detach", onto}
+	cmd := GitCommand{args, w.RepoInfo.Path}
+	result := cmd.Run()
+	if !result.Passed {
+		return false, fmt.Errorf("%s failed with ErrorCode %d: %v", cmd.Print(), result.ErrorCode, result.Output)
+	}
+	w.Branch = "DETACHED_HEAD"
+	// Merge the branch by name where there is one so the message names it
+	topic := head
+	if prevBranch != "DETACHED_HEAD" {
+		topic = prevBranch
+	}
+	merged, err := w.integrate([]string{"merge", "--no-edit", topic}, "MERGE_HEAD", onto)
+	if err != nil || (!merged && !w.KeepConflicts) {
+		// The abort leaves HEAD at onto, so put the topic back where it was
+		if resetErr := w.CheckoutAt(prevBranch, head); resetErr != nil {
+			return false, resetErr
+		}
+	}
+	return merged, err
+}
+
+// Picks the commits of the branch that are not in onto, one by one, on top of onto
+func (w *RepoWorker) cherryPickOnto(onto string) (bool, error) {
+	head, err := w.RevParseFull("HEAD")
+	if err != nil {
+		return false, err
+	}
+	args := []string{"rev-list", "--count", "--no-merges", onto + ".." + head}
+	cmd := GitCommand{args, w.RepoInfo.Path}
+	result := cmd.Run()
+	if !result.Passed || len(result.Output) != 1 {
+		return false, fmt.Errorf("%s failed with ErrorCode %d: %v", cmd.Print(), result.ErrorCode, result.Output)
+	}
+	if err := w.CheckoutAt(w.Branch, onto); err != nil {
+		return false, err
+	}
+	if result.Output[0] == "0" {
+		return true, nil
+	}
+	picked, err := w.CherryPick("--no-merges", onto+".."+head)
+	if w.Conflict != nil {
+		w.Conflict.Onto = onto
+	}
+	if err == nil && !picked && w.KeepConflicts {
+		w.PickedFrom = head
+	}
+	if err != nil || (!picked && !w.KeepConflicts) {
+		// The abort leaves the branch at onto, so put it back where it was
+		if resetErr := w.CheckoutAt(w.Branch, head); resetErr != nil {
+			return false, resetErr
+		}
+	}
+	return picked, err
+}
+
+// Cherry-picks commits onto HEAD. Returns false if there were conflicts, after aborting.
+func (w *RepoWorker) CherryPick(commits ...string) (bool, error) {
+	head, err := w.RevParseFull("HEAD")
+	if err != nil {
+		return false, err
+	}
+	args := append([]string{"cherry-pick"}, commits...)
+	return w.integrate(args, "CHERRY_PICK_HEAD", head)
+}
+
+// Replays only the commits after upstream onto onto, e.g. a single Gerrit
+// change without the rest of the history it was uploaded on
+func (w *RepoWorker) RebaseOnto(onto string, upstream string) (bool, error) {
+	return w.integrate([]string{"rebase", "--onto", onto, upstream}, "REBASE_HEAD", onto)
+}
+
+// Runs a rebase, merge or cherry-pick. On conflicts the commit that stopped it,
+// found at the pseudo-ref stopped, is recorded in w.Conflict and false is
+// returned. The operation is aborted unless w.KeepConflicts is set.
+func (w *RepoWorker) integrate(args []string, stopped string, onto string) (bool, error) {
+	w.Conflict = nil
 	cmd := GitCommand{args, w.RepoInfo.Path}
 	result := cmd.Run()
 	if result.Passed {
 		return true, nil
 	} else if result.ErrorCode == 1 {
-		abortArgs := []string{"rebase", "--abort"}
+		w.Conflict = w.conflictInfo(stopped, onto)
+		if w.KeepConflicts {
+			return false, nil
+		}
+		abortArgs := []string{args[0], "--abort"}
 		abortCmd := GitCommand{abortArgs, w.RepoInfo.Path}
 		abortResult := abortCmd.Run()
 		if abortResult.Passed {
@@ -129,6 +432,30 @@ func (w *RepoWorker) Rebase(targetBranch string) (bool, error) {
 	return false, fmt.Errorf("%s failed with ErrorCode %d: %v", cmd.Print(), result.ErrorCode, result.Output)
 }
 
+// Reads what a stopped rebase, merge or cherry-pick was doing. Anything git
+// cannot tell us is left empty.
+func (w *RepoWorker) conflictInfo(stopped string, onto string) *ConflictInfo {
+	info := &ConflictInfo{Onto: onto, Paths: make([]string, 0)}
+	if onto != "" {
+		info.OntoSHA, _ = w.RevParseFull(onto)
+	}
+	info.Commit, _ = w.RevParseFull(stopped)
+	if info.Commit != "" {
+		args := []string{"log", "-1", "--format=%s%n%an%n%ae", info.Commit}
+		cmd := GitCommand{args, w.RepoInfo.Path}
+		result := cmd.Run()
+		if result.Passed && len(result.Output) == 3 {
+			info.Subject = result.Output[0]
+			info.Author = result.Output[1]
+			info.AuthorEmail = result.Output[2]
+		}
+	}
+	if paths, err := w.UnmergedPaths(); err == nil {
+		info.Paths = append(info.Paths, paths...)
+	}
+	return info
+}
+
 func (w *RepoWorker) CheckoutRemote(targetBranch string, targetRemote string) error {
 	targetRemoteBranch := targetRemote + "/" + targetBranch
 	// The -B flag force creates a new branch if one already exists
@@ -143,6 +470,19 @@ func (w *RepoWorker) CheckoutRemote(targetBranch string, targetRemote string) er
 	return nil
 }
 
+// Creates and checks out a new branch at startPoint, tracking it, and carries
+// any local changes over to it
+func (w *RepoWorker) CheckoutNew(targetBranch string, startPoint string) error {
+	args := []string{"checkout", "-b", targetBranch, "--track", startPoint}
+	cmd := GitCommand{args, w.RepoInfo.Path}
+	result := cmd.Run()
+	if !result.Passed {
+		return fmt.Errorf("%s failed with ErrorCode %d: %v", cmd.Print(), result.ErrorCode, result.Output)
+	}
+	w.Branch = targetBranch
+	return nil
+}
+
 func (w *RepoWorker) CheckoutLocal(targetBranch string) error {
 	args := []string{"checkout", targetBranch}
 	cmd := GitCommand{args, w.RepoInfo.Path}
@@ -157,3 +497,227 @@ func (w *RepoWorker) CheckoutLocal(targetBranch string) error {
 func (w *RepoWorker) Print() {
 	fmt.Printf("Repo: %s ; Branch: %s", w.RepoInfo.Name, w.Branch)
 }
+
+func (w *RepoWorker) RevParseFull(object string) (string, error) {
+	args := []string{"rev-parse", "--verify", "--quiet", object}
+	cmd := GitCommand{args, w.RepoInfo.Path}
+	result := cmd.Run()
+	if result.Passed && len(result.Output) == 1 {
+		return result.Output[0], nil
+	}
+	return "", fmt.Errorf("%s failed with ErrorCode %d", cmd.Print(), result.ErrorCode)
+}
+
+// Returns the hash of the newest stash, or an empty string if there are no stashes
+func (w *RepoWorker) StashRef() string {
+	ref, err := w.RevParseFull("refs/stash")
+	if err != nil {
+		return ""
+	}
+	return ref
+}
+
+func (w *RepoWorker) StashApply(ref string) error {
+	args := []string{"stash", "apply", ref}
+	cmd := GitCommand{args, w.RepoInfo.Path}
+	result := cmd.Run()
+	if !result.Passed {
+		return fmt.Errorf("%s failed with ErrorCode %d: %v", cmd.Print(), result.ErrorCode, result.Output)
+	}
+	return nil
+}
+
+// Drops the stash entry whose commit hash matches ref, if it is still in the stash list
+func (w *RepoWorker) StashDropRef(ref string) error {
+	args := []string{"stash", "list", "--format=%H"}
+	cmd := GitCommand{args, w.RepoInfo.Path}
+	result := cmd.Run()
+	if !result.Passed {
+		return fmt.Errorf("%s failed with ErrorCode %d", cmd.Print(), result.ErrorCode)
+	}
+	for i, hash := range result.Output {
+		if hash != ref {
+			continue
+		}
+		dropArgs := []string{"stash", "drop", fmt.Sprintf("stash@{%d}", i)}
+		dropCmd := GitCommand{dropArgs, w.RepoInfo.Path}
+		dropResult := dropCmd.Run()
+		if !dropResult.Passed {
+			return fmt.Errorf("%s failed with ErrorCode %d", dropCmd.Print(), dropResult.ErrorCode)
+		}
+		return nil
+	}
+	return nil
+}
+
+// The operation left stopped in the repo, one of "rebase", "merge" or
+// "cherry-pick", or an empty string if there is none
+func (w *RepoWorker) OperationInProgress() string {
+	return w.operationInProgress(runContext)
+}
+
+func (w *RepoWorker) operationInProgress(ctx context.Context) string {
+	if w.gitPathExists(ctx, "rebase-merge") || w.gitPathExists(ctx, "rebase-apply") {
+		return "rebase"
+	}
+	if w.gitPathExists(ctx, "MERGE_HEAD") {
+		return "merge"
+	}
+	if w.gitPathExists(ctx, "CHERRY_PICK_HEAD") || w.gitPathExists(ctx, "sequencer") {
+		return "cherry-pick"
+	}
+	return ""
+}
+
+// Checks whether a file exists in the repo's git directory
+func (w *RepoWorker) gitPathExists(ctx context.Context, name string) bool {
+	p, err := w.gitPath(ctx, name)
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(p)
+	return err == nil
+}
+
+func (w *RepoWorker) gitPath(ctx context.Context, name string) (string, error) {
+	args := []string{"rev-parse", "--git-path", name}
+	cmd := GitCommand{args, w.RepoInfo.Path}
+	result := cmd.RunContext(ctx)
+	if !result.Passed || len(result.Output) != 1 {
+		return "", fmt.Errorf("%s failed with ErrorCode %d", cmd.Print(), result.ErrorCode)
+	}
+	p := result.Output[0]
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(w.RepoInfo.Path, p)
+	}
+	return p, nil
+}
+
+// Undoes whatever a killed git command left behind. Runs outside of the
+// cancelled run context so the cleanup itself is not killed.
+func (w *RepoWorker) Cleanup() ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), CleanupTimeout)
+	defer cancel()
+	actions := make([]string, 0)
+	// A killed git process leaves its lock behind
+	if lock, err := w.gitPath(ctx, "index.lock"); err == nil {
+		if _, err := os.Stat(lock); err == nil {
+			if err := os.Remove(lock); err != nil {
+				return actions, err
+			}
+			actions = append(actions, "removed index.lock")
+		}
+	}
+	if op := w.operationInProgress(ctx); op != "" {
+		args := []string{op, "--abort"}
+		cmd := GitCommand{args, w.RepoInfo.Path}
+		result := cmd.RunContext(ctx)
+		if !result.Passed {
+			return actions, fmt.Errorf("%s failed with ErrorCode %d: %v", cmd.Print(), result.ErrorCode, result.Output)
+		}
+		actions = append(actions, "aborted "+op)
+	}
+	return actions, nil
+}
+
+// Aborts a stopped rebase, merge or cherry-pick, putting the branch back
+func (w *RepoWorker) AbortOperation(op string) error {
+	args := []string{op, "--abort"}
+	cmd := GitCommand{args, w.RepoInfo.Path}
+	result := cmd.Run()
+	if !result.Passed {
+		return fmt.Errorf("%s failed with ErrorCode %d: %v", cmd.Print(), result.ErrorCode, result.Output)
+	}
+	w.Branch, _ = (&RepoWorkerInitializer{w.RepoInfo}).CurrentBranch()
+	return nil
+}
+
+// Continues a stopped rebase, merge or cherry-pick once its conflicts are
+// resolved, keeping the commit messages as they are. Returns false if it
+// stopped on another conflict, which is recorded in w.Conflict and left open.
+func (w *RepoWorker) ContinueOperation(op string) (bool, error) {
+	w.Conflict = nil
+	args := []string{"-c", "core.editor=true", op, "--continue"}
+	cmd := GitCommand{args, w.RepoInfo.Path}
+	result := cmd.Run()
+	if !result.Passed && result.ErrorCode != 1 {
+		return false, fmt.Errorf("%s failed with ErrorCode %d: %v", cmd.Print(), result.ErrorCode, result.Output)
+	}
+	if w.OperationInProgress() != "" {
+		stopped := map[string]string{"rebase": "REBASE_HEAD", "merge": "HEAD", "cherry-pick": "CHERRY_PICK_HEAD"}[op]
+		w.Conflict = w.conflictInfo(stopped, "")
+		return false, nil
+	}
+	if !result.Passed {
+		return false, fmt.Errorf("%s failed with ErrorCode %d: %v", cmd.Print(), result.ErrorCode, result.Output)
+	}
+	w.Branch, _ = (&RepoWorkerInitializer{w.RepoInfo}).CurrentBranch()
+	return true, nil
+}
+
+// Paths with conflicts that have not been marked as resolved
+func (w *RepoWorker) UnmergedPaths() ([]string, error) {
+	args := []string{"diff", "--name-only", "--diff-filter=U"}
+	cmd := GitCommand{args, w.RepoInfo.Path}
+	result := cmd.Run()
+	if !result.Passed {
+		return nil, fmt.Errorf("%s failed with ErrorCode %d: %v", cmd.Print(), result.ErrorCode, result.Output)
+	}
+	return result.Output, nil
+}
+
+// Pushes the local branch to the same name on the remote, as long as the
+// remote branch is still at expected, the SHA last fetched. An empty expected
+// means the branch must not exist on the remote yet.
+func (w *RepoWorker) Push(remote string, branch string, expected string, setUpstream bool) error {
+	args := []string{"push", fmt.Sprintf("--force-with-lease=refs/heads/%s:%s", branch, expected)}
+	if setUpstream {
+		args = append(args, "--set-upstream")
+	}
+	args = append(args, remote, fmt.Sprintf("refs/heads/%s:refs/heads/%s", branch, branch))
+	cmd := GitCommand{args, w.RepoInfo.Path}
+	result := cmd.Run()
+	if !result.Passed {
+		return fmt.Errorf("%s failed with ErrorCode %d", cmd.Print(), result.ErrorCode)
+	}
+	return nil
+}
+
+// Fetches refs that are not branches, such as Gerrit's refs/changes, without
+// storing them anywhere but FETCH_HEAD
+func (w *RepoWorker) FetchRefs(remote string, refs ...string) error {
+	args := append([]string{"fetch", remote}, refs...)
+	cmd := GitCommand{args, w.RepoInfo.Path}
+	result := cmd.Run()
+	if !result.Passed {
+		return fmt.Errorf("%s failed with ErrorCode %d: %v", cmd.Print(), result.ErrorCode, result.Output)
+	}
+	return nil
+}
+
+// Force creates targetBranch at commit and checks it out. The branch keeps its
+// upstream even when commit is a remote-tracking branch such as origin/master.
+func (w *RepoWorker) CheckoutAt(targetBranch string, commit string) error {
+	args := []string{"checkout", "-f", "-B", targetBranch, "--no-track", commit}
+	if targetBranch == "DETACHED_HEAD" {
+		args = []string{"checkout", "-f", "--detach", commit}
+	}
+	cmd := GitCommand{args, w.RepoInfo.Path}
+	result := cmd.Run()
+	if !result.Passed {
+		return fmt.Errorf("%s failed with ErrorCode %d: %v", cmd.Print(), result.ErrorCode, result.Output)
+	}
+	w.Branch = targetBranch
+	return nil
+}
+
+// Moves a branch that is not checked out to commit
+func (w *RepoWorker) ResetBranch(branch string, commit string) error {
+	args := []string{"branch", "-f", branch, commit}
+	cmd := GitCommand{args, w.RepoInfo.Path}
+	result := cmd.Run()
+	if !result.Passed {
+		return fmt.Errorf("%s failed with ErrorCode %d: %v", cmd.Print(), result.ErrorCode, result.Output)
+	}
+	return nil
+}
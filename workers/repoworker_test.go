@@ -39,7 +39,7 @@ func TestRepoWorkerInit_Private(t *testing.T) {
 		t.Skip("No config file available, skipping")
 	}
 	config1 := config.Test1
-	repoInfo := workers.RepoInfo{config1.SampleRepoPath, config1.SampleRepoName}
+	repoInfo := workers.RepoInfo{Path: config1.SampleRepoPath, Name: config1.SampleRepoName}
 	init := workers.RepoWorkerInitializer{&repoInfo}
 	rw, err := init.NewRepoWorker()
 	if err != nil {
@@ -59,7 +59,7 @@ func TestRepoWorkerInit(t *testing.T) {
 		panic(err)
 	}
 	repoPath := filepath.Dir(currentPath)
-	repoInfo := workers.RepoInfo{repoPath, "test"}
+	repoInfo := workers.RepoInfo{Path: repoPath, Name: "test"}
 	init := workers.RepoWorkerInitializer{&repoInfo}
 	rw, err := init.NewRepoWorker()
 	if err != nil {
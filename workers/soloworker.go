@@ -1,13 +1,11 @@
 package workers
 
 import (
-	"bufio"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"log"
-	"os/exec"
-	"strings"
+	"os"
+	"path/filepath"
 )
 
 type SoloWorker struct {
@@ -19,45 +17,35 @@ type SoloWorker struct {
 type RepoInfo struct {
 	Path string `json:"path"`
 	Name string `json:"name"`
+	// Everything below is read from the manifest
+	RelPath    string   `json:"relpath,omitempty"`
+	Remote     string   `json:"remote,omitempty"`
+	RemoteURL  string   `json:"remoteurl,omitempty"`
+	Revision   string   `json:"revision,omitempty"`
+	DestBranch string   `json:"destbranch,omitempty"`
+	Upstream   string   `json:"upstream,omitempty"`
+	Groups     []string `json:"groups,omitempty"`
 }
 
 type RepoList struct {
 	RepoList []*RepoInfo
 }
 
+// Parses the workspace's repo manifest and saves the projects to the repo list file
 func (worker *SoloWorker) Refresh() error {
-	cmd := exec.Command("repo", "list", "-f")
-	cmd.Dir = worker.CodeDir
-	stdout, err := cmd.StdoutPipe()
-	rd := bufio.NewReader(stdout)
+	list, err := ParseManifest(worker.CodeDir)
 	if err != nil {
-		log.Fatal(err)
-	}
-	if err := cmd.Start(); err != nil {
-		return err
-	}
-
-	list := make([]*RepoInfo, 0)
-	line, err := rd.ReadString('\n')
-	if err != nil {
-		fmt.Printf("Error performing 'repo list', no entries found.\n")
 		return err
 	}
-	for err == nil {
-		chunks := strings.SplitN(line, " : ", 2)
-		p := strings.Trim(chunks[0], " \n\r")
-		n := strings.Trim(chunks[1], " \n\r")
-		repoInfo := RepoInfo{Path: p, Name: n}
-		list = append(list, &repoInfo)
-		line, err = rd.ReadString('\n')
-	}
-
-	if err := cmd.Wait(); err != nil {
-		return err
+	if len(list) == 0 {
+		return fmt.Errorf("no projects found in the manifest in %s", worker.CodeDir)
 	}
 
 	repoList := RepoList{list}
 	jsontext, _ := json.MarshalIndent(&repoList, "", "\t")
+	if err := os.MkdirAll(filepath.Dir(worker.RepolistFilename), 0755); err != nil {
+		return err
+	}
 	if err := ioutil.WriteFile(worker.RepolistFilename, jsontext, 0644); err != nil {
 		return err
 	}
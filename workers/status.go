@@ -0,0 +1,112 @@
+package workers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Where a repo stands, as reported by yeet status
+type RepoState struct {
+	Upstream string `json:"upstream,omitempty"`
+	// Commits relative to the upstream of the current branch
+	Ahead  int `json:"ahead"`
+	Behind int `json:"behind"`
+	// Commits relative to the remote base branch
+	Base       string `json:"base,omitempty"`
+	BaseAhead  int    `json:"base_ahead"`
+	BaseBehind int    `json:"base_behind"`
+	Staged     int    `json:"staged"`
+	Unstaged   int    `json:"unstaged"`
+	Untracked  int    `json:"untracked"`
+	Unmerged   int    `json:"unmerged"`
+	Stashes    int    `json:"stashes"`
+	// rebase, merge or cherry-pick, if one is stopped in the repo
+	InProgress string `json:"in_progress,omitempty"`
+}
+
+// Reads the output of `git status --porcelain=v2 --branch`
+func ParseStatus(lines []string) *RepoState {
+	state := &RepoState{}
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "#":
+			if len(fields) >= 3 && fields[1] == "branch.upstream" {
+				state.Upstream = fields[2]
+			} else if len(fields) >= 4 && fields[1] == "branch.ab" {
+				state.Ahead, _ = strconv.Atoi(strings.TrimPrefix(fields[2], "+"))
+				state.Behind, _ = strconv.Atoi(strings.TrimPrefix(fields[3], "-"))
+			}
+		case "1", "2":
+			// The XY field holds the staged and unstaged state, "." meaning unchanged
+			if len(fields) < 2 || len(fields[1]) != 2 {
+				continue
+			}
+			if fields[1][0] != '.' {
+				state.Staged++
+			}
+			if fields[1][1] != '.' {
+				state.Unstaged++
+			}
+		case "u":
+			state.Unmerged++
+		case "?":
+			state.Untracked++
+		}
+	}
+	return state
+}
+
+// Any change to the working tree or index, including untracked files
+func (s *RepoState) Dirty() bool {
+	return s.Staged+s.Unstaged+s.Untracked+s.Unmerged > 0
+}
+
+// The status code that sums up the state, most pressing first
+func (s *RepoState) Status() Status {
+	switch {
+	case s.InProgress != "":
+		return INPROG
+	case s.Ahead > 0 && s.Behind > 0:
+		return DIVRGD
+	case s.Behind > 0:
+		return BEHIND
+	case s.Ahead > 0:
+		return AHEAD
+	case s.Dirty():
+		return DIRTY
+	}
+	return CURRNT
+}
+
+// The parts of the state worth printing, e.g. "+2/-1 origin/feat, 3 untracked"
+func (s *RepoState) Summary() string {
+	parts := make([]string, 0)
+	if s.Upstream == "" {
+		parts = append(parts, "no upstream")
+	} else if s.Ahead > 0 || s.Behind > 0 {
+		parts = append(parts, fmt.Sprintf("+%d/-%d %s", s.Ahead, s.Behind, s.Upstream))
+	}
+	if s.Base != "" && s.Base != s.Upstream && (s.BaseAhead > 0 || s.BaseBehind > 0) {
+		parts = append(parts, fmt.Sprintf("+%d/-%d %s", s.BaseAhead, s.BaseBehind, s.Base))
+	}
+	counts := []struct {
+		n    int
+		name string
+	}{
+		{s.Staged, "staged"}, {s.Unstaged, "unstaged"}, {s.Untracked, "untracked"}, {s.Unmerged, "unmerged"}, {s.Stashes, "stashed"},
+	}
+	for _, c := range counts {
+		if c.n > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", c.n, c.name))
+		}
+	}
+	if s.InProgress != "" {
+		parts = append(parts, s.InProgress+" in progress")
+	}
+	return strings.Join(parts, ", ")
+}
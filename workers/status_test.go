@@ -0,0 +1,53 @@
+package workers_test
+
+import (
+	"testing"
+	workers "yeet/workers"
+)
+
+func TestParseStatus(t *testing.T) {
+	lines := []string{
+		"# branch.oid 0123456789abcdef0123456789abcdef01234567",
+		"# branch.head feature123",
+		"# branch.upstream origin/feature123",
+		"# branch.ab +2 -1",
+		"1 M. N... 100644 100644 100644 aaaa bbbb staged.c",
+		"1 .M N... 100644 100644 100644 aaaa bbbb unstaged.c",
+		"1 MM N... 100644 100644 100644 aaaa bbbb both.c",
+		"2 R. N... 100644 100644 100644 aaaa bbbb R100 new.c\told.c",
+		"u UU N... 100644 100644 100644 100644 aaaa bbbb cccc conflict.c",
+		"? untracked.txt",
+	}
+	state := workers.ParseStatus(lines)
+	if state.Upstream != "origin/feature123" || state.Ahead != 2 || state.Behind != 1 {
+		t.Fatalf(`Got upstream %s +%d/-%d, expected origin/feature123 +2/-1`, state.Upstream, state.Ahead, state.Behind)
+	}
+	if state.Staged != 3 || state.Unstaged != 2 || state.Unmerged != 1 || state.Untracked != 1 {
+		t.Fatalf(`Got %d staged, %d unstaged, %d unmerged, %d untracked`, state.Staged, state.Unstaged, state.Unmerged, state.Untracked)
+	}
+	if got := state.Status(); got != workers.DIVRGD {
+		t.Fatalf(`Expected DIVRGD, got %s`, got.ToString())
+	}
+	state.InProgress = "rebase"
+	if got := state.Status(); got != workers.INPROG {
+		t.Fatalf(`Expected INPROG, got %s`, got.ToString())
+	}
+}
+
+func TestRepoStateStatus(t *testing.T) {
+	cases := []struct {
+		state workers.RepoState
+		want  workers.Status
+	}{
+		{workers.RepoState{Upstream: "origin/main"}, workers.CURRNT},
+		{workers.RepoState{Upstream: "origin/main", Ahead: 1}, workers.AHEAD},
+		{workers.RepoState{Upstream: "origin/main", Behind: 3}, workers.BEHIND},
+		{workers.RepoState{Upstream: "origin/main", Untracked: 1}, workers.DIRTY},
+		{workers.RepoState{Upstream: "origin/main", Behind: 1, Staged: 1}, workers.BEHIND},
+	}
+	for i, c := range cases {
+		if got := c.state.Status(); got != c.want {
+			t.Fatalf(`Case %d: got %s, expected %s`, i, got.ToString(), c.want.ToString())
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package workers
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/TwiN/go-color"
+	"golang.org/x/exp/slices"
+)
+
+// A remote branch found in several repos by yeet topics
+type TopicResult struct {
+	Branch string   `json:"branch"`
+	Repos  []string `json:"repos"`
+	// The newest tip commit across the repos
+	Newest  time.Time `json:"newest"`
+	Authors []string  `json:"authors"`
+}
+
+func (t *TopicResult) Format() string {
+	return fmt.Sprintf("%s%s%s: %d repos, newest %s by %s\n    %s\n", color.Green, t.Branch, color.Reset,
+		len(t.Repos), t.Newest.Format("2006-01-02"), strings.Join(t.Authors, ", "), strings.Join(t.Repos, " "))
+}
+
+// Collects the branches found in at least minRepos repos from the remote
+// branches of each repo, keyed by repo name then branch name without the
+// remote. The topics are ordered newest first.
+func FindTopics(branches map[string]map[string]*BranchRef, minRepos int) []*TopicResult {
+	byBranch := make(map[string]*TopicResult)
+	for repo, refs := range branches {
+		for branch, ref := range refs {
+			topic, ok := byBranch[branch]
+			if !ok {
+				topic = &TopicResult{Branch: branch, Repos: make([]string, 0), Authors: make([]string, 0)}
+				byBranch[branch] = topic
+			}
+			topic.Repos = append(topic.Repos, repo)
+			if ref.Date.After(topic.Newest) {
+				topic.Newest = ref.Date
+			}
+			if ref.Author != "" && !slices.Contains(topic.Authors, ref.Author) {
+				topic.Authors = append(topic.Authors, ref.Author)
+			}
+		}
+	}
+	topics := make([]*TopicResult, 0)
+	for _, topic := range byBranch {
+		if len(topic.Repos) < minRepos {
+			continue
+		}
+		sort.Strings(topic.Repos)
+		sort.Strings(topic.Authors)
+		topics = append(topics, topic)
+	}
+	sort.Slice(topics, func(i, j int) bool {
+		if !topics[i].Newest.Equal(topics[j].Newest) {
+			return topics[i].Newest.After(topics[j].Newest)
+		}
+		return topics[i].Branch < topics[j].Branch
+	})
+	return topics
+}
@@ -0,0 +1,42 @@
+package workers_test
+
+import (
+	"testing"
+	"time"
+	workers "yeet/workers"
+)
+
+func TestFindTopics(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2024, 5, d, 12, 0, 0, 0, time.UTC) }
+	branches := map[string]map[string]*workers.BranchRef{
+		"platform/core": {
+			"feature/login": {Author: "A Coworker", Date: day(1)},
+			"feature/audio": {Author: "B Coworker", Date: day(3)},
+			"wip":           {Author: "A Coworker", Date: day(9)},
+		},
+		"ui/app": {
+			"feature/login": {Author: "B Coworker", Date: day(4)},
+			"feature/audio": {Author: "B Coworker", Date: day(2)},
+		},
+		"ui/widgets": {
+			"feature/login": {Author: "A Coworker", Date: day(2)},
+		},
+	}
+	topics := workers.FindTopics(branches, 2)
+	if len(topics) != 2 {
+		t.Fatalf(`Got %d topics, expected 2: %v`, len(topics), topics)
+	}
+	login := topics[0]
+	if login.Branch != "feature/login" || len(login.Repos) != 3 || login.Repos[0] != "platform/core" {
+		t.Fatalf(`Expected feature/login in 3 repos first, got %s in %v`, login.Branch, login.Repos)
+	}
+	if !login.Newest.Equal(day(4)) || len(login.Authors) != 2 {
+		t.Fatalf(`Got newest %s by %v for feature/login`, login.Newest, login.Authors)
+	}
+	if topics[1].Branch != "feature/audio" || len(topics[1].Authors) != 1 {
+		t.Fatalf(`Expected feature/audio by one author, got %s by %v`, topics[1].Branch, topics[1].Authors)
+	}
+	if len(workers.FindTopics(branches, 3)) != 1 || len(workers.FindTopics(branches, 1)) != 3 {
+		t.Fatalf(`--min-repos not applied`)
+	}
+}
@@ -2,275 +2,1338 @@ package workers
 
 import (
 	"fmt"
+	"regexp"
 	"strings"
 	"sync"
+	"time"
 
 	"golang.org/x/exp/slices"
 )
 
 func statusWorkflow(init *RepoWorkerInitializer, done chan<- *WorkFlowResult) {
-	var wfr *WorkFlowResult
-	var localHEAD string
-	var remoteHEAD string
+	if interrupted() {
+		done <- newResult(init.RepoInfo.Name, INTRPT, "Not started")
+		return
+	}
 	rw, err := init.NewRepoWorker()
 	if err != nil {
-		panic(err)
+		done <- workerFailed(init.RepoInfo, err)
+		return
 	}
-	remotes := rw.Remotes
-	var remote string
-	if len(remotes) == 1 {
-		remote = remotes[0]
-	} else if slices.Contains(remotes, config.FCRemote) {
-		remote = config.FCRemote
-	} else {
-		done <- &WorkFlowResult{rw.RepoInfo.Name, FAILED, "Correct remote not found"}
+	localSHA, _ := rw.RevParseFull("HEAD")
+	remote, err := selectRemote(rw.Remotes)
+	if err != nil {
+		done <- failedWorkflow(rw, err.Error()).describe(rw, rw.Branch, localSHA)
+		return
+	}
+	if err = updateRemote(rw, remote); err != nil {
+		done <- failedWorkflow(rw, "Error performing remote update: "+err.Error()).describe(rw, rw.Branch, localSHA)
+		return
+	}
+	lines, err := rw.StatusBranch()
+	if err != nil {
+		done <- failedWorkflow(rw, err.Error()).describe(rw, rw.Branch, localSHA)
+		return
+	}
+	state := ParseStatus(lines)
+	base := fmt.Sprintf("%s/%s", remote, baseBranchFor(rw.RepoInfo))
+	if ahead, behind, err := rw.AheadBehind("HEAD", base); err == nil {
+		state.Base, state.BaseAhead, state.BaseBehind = base, ahead, behind
+	}
+	state.Stashes, _ = rw.StashCount()
+	state.InProgress = rw.OperationInProgress()
+
+	localHEAD, _ := rw.RevParseObject("HEAD")
+	message := fmt.Sprintf("[%s]: [%s]", rw.Branch, localHEAD)
+	if summary := state.Summary(); summary != "" {
+		message += " " + summary
+	}
+	wfr := newResult(rw.RepoInfo.Name, state.Status(), message)
+	wfr.State = state
+	if interrupted() {
+		// The counts above may be missing from git commands that were killed
+		done <- interruptWorkflow(rw, wfr).describe(rw, rw.Branch, localSHA)
+		return
+	}
+	done <- wfr.describe(rw, rw.Branch, localSHA)
+}
+
+// Updates every remote of the repo, whatever the fetch TTL, and records the
+// fetch in the cache
+func fetchWorkflow(init *RepoWorkerInitializer, done chan<- *WorkFlowResult) {
+	if interrupted() {
+		done <- newResult(init.RepoInfo.Name, INTRPT, "Not started")
+		return
+	}
+	rw, err := init.NewRepoWorker()
+	if err != nil {
+		done <- workerFailed(init.RepoInfo, err)
+		return
+	}
+	localSHA, _ := rw.RevParseFull("HEAD")
+	if err := rw.Update(); err != nil {
+		done <- failedWorkflow(rw, "Error performing remote update: "+err.Error()).describe(rw, rw.Branch, localSHA)
+		return
+	}
+	now := time.Now()
+	for _, remote := range rw.Remotes {
+		if err := fetches.Record(rw.RepoInfo.Path, remote, now); err != nil {
+			done <- failedWorkflow(rw, "Error saving the fetch cache: "+err.Error()).describe(rw, rw.Branch, localSHA)
+			return
+		}
+	}
+	message := fmt.Sprintf("[%s]: fetched %s", rw.Branch, strings.Join(rw.Remotes, ", "))
+	done <- newResult(rw.RepoInfo.Name, PASSED, message).describe(rw, rw.Branch, localSHA)
+}
+
+// Pushes the branch, or the current branch if none is given, from a repo that
+// has it locally. The push is forced, since take rewrites topics, but only
+// over the remote commit seen at the last fetch so nobody else's work is lost.
+func pushWorkflow(branch string, setUpstream bool, init *RepoWorkerInitializer, done chan<- *WorkFlowResult) {
+	if interrupted() {
+		done <- newResult(init.RepoInfo.Name, INTRPT, "Not started")
+		return
+	}
+	rw, err := init.NewRepoWorker()
+	if err != nil {
+		done <- workerFailed(init.RepoInfo, err)
+		return
+	}
+	localSHA, _ := rw.RevParseFull("HEAD")
+	if branch == "" {
+		branch = rw.Branch
+	}
+	// Forced pushes are only for topic branches, never the shared base branch
+	if branch == "DETACHED_HEAD" || branch == baseBranchFor(rw.RepoInfo) {
+		done <- newResult(rw.RepoInfo.Name, SKIPPD, fmt.Sprintf("[%s]: %s is not a topic branch, not pushing it", rw.Branch, branch)).describe(rw, rw.Branch, localSHA)
+		return
+	}
+	branchSHA, err := rw.RevParseFull("refs/heads/" + branch)
+	if err != nil {
+		done <- newResult(rw.RepoInfo.Name, CURRNT, fmt.Sprintf("[%s]: no local %s, nothing to push", rw.Branch, branch)).describe(rw, rw.Branch, localSHA)
+		return
+	}
+	remote, err := selectRemote(rw.Remotes)
+	if err != nil {
+		done <- failedWorkflow(rw, err.Error()).describe(rw, rw.Branch, localSHA)
+		return
+	}
+	remoteBranch := fmt.Sprintf("%s/%s", remote, branch)
+	remoteSHA, _ := rw.RevParseFull("refs/remotes/" + remoteBranch)
+	branchHEAD, _ := rw.RevParseObject(branchSHA)
+	if remoteSHA == branchSHA && !setUpstream {
+		done <- newResult(rw.RepoInfo.Name, CURRNT, fmt.Sprintf("[%s]: [%s] already on %s", branch, branchHEAD, remoteBranch)).describe(rw, rw.Branch, localSHA)
+		return
+	}
+	if err := rw.Push(remote, branch, remoteSHA, setUpstream); err != nil {
+		message := fmt.Sprintf("[%s]: %s, has %s changed since it was last fetched?", branch, err, remoteBranch)
+		done <- failedWorkflow(rw, message).describe(rw, rw.Branch, localSHA)
+		return
+	}
+	message := fmt.Sprintf("[%s]: [%s] created %s", branch, branchHEAD, remoteBranch)
+	if remoteSHA != "" {
+		remoteHEAD, _ := rw.RevParseObject(remoteSHA)
+		message = fmt.Sprintf("[%s]: [%s]->[%s] pushed to %s", branch, remoteHEAD, branchHEAD, remoteBranch)
+	}
+	done <- newResult(rw.RepoInfo.Name, PASSED, message).describe(rw, rw.Branch, localSHA)
+}
+
+// Creates the topic branch from the freshly fetched base branch, tracking it.
+// With changed set, only repos with local changes are touched, and the
+// changes move over to the new branch.
+func startWorkflow(branch string, changed bool, init *RepoWorkerInitializer, done chan<- *WorkFlowResult) {
+	if interrupted() {
+		done <- newResult(init.RepoInfo.Name, INTRPT, "Not started")
+		return
+	}
+	rw, err := init.NewRepoWorker()
+	if err != nil {
+		done <- workerFailed(init.RepoInfo, err)
+		return
+	}
+	oldBranch := rw.Branch
+	localSHA, _ := rw.RevParseFull("HEAD")
+	localHEAD, _ := rw.RevParseObject("HEAD")
+	if changed {
+		dirty, err := rw.IsDirty()
+		if err != nil {
+			done <- failedWorkflow(rw, err.Error()).describe(rw, oldBranch, localSHA)
+			return
+		}
+		if !dirty {
+			done <- newResult(rw.RepoInfo.Name, CURRNT, fmt.Sprintf("[%s]: [%s] no local changes", rw.Branch, localHEAD)).describe(rw, oldBranch, localSHA)
+			return
+		}
+	}
+	if _, err := rw.RevParseFull("refs/heads/" + branch); err == nil {
+		if rw.Branch == branch {
+			done <- newResult(rw.RepoInfo.Name, CURRNT, fmt.Sprintf("[%s]: [%s] already on %s", rw.Branch, localHEAD, branch)).describe(rw, oldBranch, localSHA)
+		} else {
+			done <- newResult(rw.RepoInfo.Name, SKIPPD, fmt.Sprintf("[%s]: %s already exists, use take to check it out", rw.Branch, branch)).describe(rw, oldBranch, localSHA)
+		}
+		return
+	}
+	remote, err := selectRemote(rw.Remotes)
+	if err != nil {
+		done <- failedWorkflow(rw, err.Error()).describe(rw, oldBranch, localSHA)
+		return
+	}
+	if err := updateRemote(rw, remote); err != nil {
+		done <- failedWorkflow(rw, "Error performing remote update: "+err.Error()).describe(rw, oldBranch, localSHA)
+		return
+	}
+	base := fmt.Sprintf("%s/%s", remote, baseBranchFor(rw.RepoInfo))
+	if err := rw.CheckoutNew(branch, base); err != nil {
+		done <- failedWorkflow(rw, err.Error()).describe(rw, oldBranch, localSHA)
+		return
+	}
+	newHEAD, _ := rw.RevParseObject("HEAD")
+	message := fmt.Sprintf("[%s]->[%s]: [%s] from %s", oldBranch, branch, newHEAD, base)
+	done <- newResult(rw.RepoInfo.Name, PASSED, message).describe(rw, oldBranch, localSHA)
+}
+
+// The remote branches of a repo, keyed by name without the remote, gathered
+// for yeet topics
+type repoBranches struct {
+	info     *RepoInfo
+	branches map[string]*BranchRef
+	cached   bool
+	err      error
+}
+
+// Fetches the repo once and reads its remote branches, leaving out the base
+// branch every repo has
+func topicsWorkflow(init *RepoWorkerInitializer, done chan<- *repoBranches) {
+	if interrupted() {
+		done <- &repoBranches{info: init.RepoInfo, err: fmt.Errorf("Not started")}
+		return
+	}
+	rw, err := init.NewRepoWorker()
+	if err != nil {
+		done <- &repoBranches{info: init.RepoInfo, err: interruptedErr(err)}
+		return
+	}
+	remote, err := selectRemote(rw.Remotes)
+	if err != nil {
+		done <- &repoBranches{info: rw.RepoInfo, err: err}
+		return
+	}
+	if err := updateRemote(rw, remote); err != nil {
+		done <- &repoBranches{info: rw.RepoInfo, err: interruptedErr(fmt.Errorf("Error performing remote update: %s", err))}
+		return
+	}
+	remotePrefix := fmt.Sprintf("refs/remotes/%s/", remote)
+	refs, err := rw.BranchRefs(remotePrefix)
+	if err != nil {
+		done <- &repoBranches{info: rw.RepoInfo, err: interruptedErr(err)}
+		return
+	}
+	base := baseBranchFor(rw.RepoInfo)
+	branches := make(map[string]*BranchRef)
+	for _, ref := range refs {
+		branch := strings.TrimPrefix(ref.Ref, remotePrefix)
+		if branch != "HEAD" && branch != base {
+			branches[branch] = ref
+		}
+	}
+	done <- &repoBranches{info: rw.RepoInfo, branches: branches, cached: rw.Cached}
+}
+
+// Lists every local branch and remote-tracking branch of the remote whose name
+// matches the pattern, with its tip and how far it is from the base branch.
+// Remote branches are matched without the remote name.
+func findWorkflow(pattern *regexp.Regexp, init *RepoWorkerInitializer, name chan<- *SearchResult, wg *sync.WaitGroup) {
+	defer wg.Done()
+	if interrupted() {
+		return
+	}
+	rw, err := init.NewRepoWorker()
+	if err != nil {
+		name <- &SearchResult{RepoName: init.RepoInfo.Name, Path: init.RepoInfo.Path, Error: interruptedErr(err).Error()}
 		return
 	}
-	err = rw.Update(remote)
+	remote, err := selectRemote(rw.Remotes)
 	if err != nil {
-		wfr = &WorkFlowResult{rw.RepoInfo.Name, FAILED, "Error performing remote update: " + err.Error()}
-		goto statusEND
+		name <- &SearchResult{RepoName: rw.RepoInfo.Name, Path: rw.RepoInfo.Path, Error: interruptedErr(err).Error()}
+		return
+	}
+	if err := updateRemote(rw, remote); err != nil {
+		name <- &SearchResult{RepoName: rw.RepoInfo.Name, Path: rw.RepoInfo.Path, Error: interruptedErr(fmt.Errorf("Error performing remote update: %s", err)).Error()}
+		return
 	}
-	localHEAD, _ = rw.RevParseObject("HEAD")
-	remoteHEAD, err = rw.RevParseUpstream(rw.Branch)
+	remotePrefix := fmt.Sprintf("refs/remotes/%s/", remote)
+	refs, err := rw.BranchRefs("refs/heads/", remotePrefix)
 	if err != nil {
-		wfr = &WorkFlowResult{rw.RepoInfo.Name, CURRNT, fmt.Sprintf("[%s]: [%s] (no upstream)", rw.Branch, localHEAD)}
-	} else if localHEAD == remoteHEAD {
-		wfr = &WorkFlowResult{rw.RepoInfo.Name, CURRNT, fmt.Sprintf("[%s]: [%s]", rw.Branch, localHEAD)}
+		name <- &SearchResult{RepoName: rw.RepoInfo.Name, Path: rw.RepoInfo.Path, Error: interruptedErr(err).Error()}
+		return
+	}
+	base := fmt.Sprintf("%s/%s", remote, baseBranchFor(rw.RepoInfo))
+	for _, ref := range refs {
+		branch := strings.TrimPrefix(ref.Ref, "refs/heads/")
+		isRemote := strings.HasPrefix(ref.Ref, remotePrefix)
+		if isRemote {
+			branch = strings.TrimPrefix(ref.Ref, remotePrefix)
+		}
+		if branch == "HEAD" || !pattern.MatchString(branch) {
+			continue
+		}
+		result := &SearchResult{
+			RepoName:  rw.RepoInfo.Name,
+			Path:      rw.RepoInfo.Path,
+			Target:    branch,
+			Remote:    isRemote,
+			SHA:       ref.SHA,
+			Author:    ref.Author,
+			Date:      ref.Date,
+			Base:      base,
+			Cached:    rw.Cached,
+			Offline:   Offline,
+			FetchedAt: rw.fetchedAt(),
+		}
+		if isRemote {
+			result.Target = remote + "/" + branch
+		}
+		result.Ahead, result.Behind, _ = rw.AheadBehind(ref.Ref, base)
+		if interrupted() {
+			name <- &SearchResult{RepoName: rw.RepoInfo.Name, Path: rw.RepoInfo.Path, Error: interruptReason()}
+			return
+		}
+		name <- result
+	}
+}
+
+func takeWorkflow(targets []string, init *RepoWorkerInitializer, journal *Journal, opts *TakeOptions, done chan<- *WorkFlowResult) {
+	if interrupted() {
+		done <- newResult(init.RepoInfo.Name, INTRPT, "Not started")
+		return
+	}
+	rw, err := init.NewRepoWorker()
+	if err != nil {
+		done <- workerFailed(init.RepoInfo, err)
+		return
+	}
+	// Record the original state before touching anything
+	entry := newJournalEntry(rw, append([]string{baseBranchFor(rw.RepoInfo)}, targets...)...)
+	if err := journal.Record(entry); err != nil {
+		done <- failedWorkflow(rw, fmt.Sprintf("Error writing journal: %s", err.Error())).describe(rw, entry.Branch, entry.HEAD)
+		return
+	}
+	// Stash current changes on branch. If they cannot be stashed, e.g. because
+	// of unmerged paths, the checkouts below would throw them away
+	stash, err := rw.Stash(fmt.Sprintf("%s%s before take %s", StashPrefix, rw.Branch, strings.Join(targets, " ")))
+	if err != nil {
+		done <- failedWorkflow(rw, fmt.Sprintf("[%s]: cannot stash local changes, leaving the repo alone: %s", rw.Branch, err.Error())).describe(rw, entry.Branch, entry.HEAD)
+		return
+	}
+	if stash != "" {
+		_ = journal.SetStash(entry, stash)
+	}
+	rw.KeepConflicts = opts.KeepConflicts
+	// What is left to do if a conflict is kept open for yeet continue
+	pending := &PendingTake{Targets: targets, Strategy: opts.Strategy}
+	var wfr *WorkFlowResult
+	if changes := opts.gerritChanges[rw.RepoInfo.Name]; len(changes) > 0 {
+		pending.Branch = targets[0]
+		wfr, pending.Changes = takeChanges(changes, targets[0], rw, opts.Strategy)
+	} else if opts.gerritChanges != nil {
+		// Repos without changes are brought up to their base branch
+		pending.Targets = nil
+		wfr = take(nil, rw, opts.Strategy)
 	} else {
-		wfr = &WorkFlowResult{rw.RepoInfo.Name, BEHIND, fmt.Sprintf("[%s]: [%s]<->[%s]", rw.Branch, localHEAD, remoteHEAD)}
+		wfr = take(targets, rw, opts.Strategy)
 	}
-statusEND:
-	done <- wfr
+	if interrupted() {
+		done <- interruptWorkflow(rw, wfr).describe(rw, entry.Branch, entry.HEAD)
+		return
+	}
+	if wfr.Status == CNFLCT {
+		wfr.Conflict = rw.Conflict
+		if op := rw.OperationInProgress(); op != "" {
+			pending.Head = rw.PickedFrom
+			_ = journal.SetPending(entry, pending)
+			wfr.Message += fmt.Sprintf(" (%s left open)", op)
+		}
+	}
+	if wfr.Status == PASSED && opts.RestoreStash {
+		restoreStash(rw, wfr)
+	} else if stash != "" {
+		wfr.Message += " (changes stashed)"
+	}
+	done <- wfr.describe(rw, entry.Branch, entry.HEAD)
 }
 
-func findWorkflow(target string, init *RepoWorkerInitializer, name chan<- *SearchResult, wg *sync.WaitGroup) {
+// Brings the repo to the tip of its base branch, as take does for repos
+// without the topic. Repos with uncommitted changes are skipped unless force
+// is set, in which case the changes are stashed first.
+func syncWorkflow(force bool, strategy string, init *RepoWorkerInitializer, done chan<- *WorkFlowResult) {
+	if interrupted() {
+		done <- newResult(init.RepoInfo.Name, INTRPT, "Not started")
+		return
+	}
 	rw, err := init.NewRepoWorker()
 	if err != nil {
-		panic(err)
+		done <- workerFailed(init.RepoInfo, err)
+		return
 	}
-	var remote string
-	remotes := rw.Remotes
-	fmtRemoteTarget := fmt.Sprintf("remotes/%s/%s", remote, target)
-	branches, err := rw.BranchList()
+	oldBranch := rw.Branch
+	localSHA, _ := rw.RevParseFull("HEAD")
+	localHEAD, _ := rw.RevParseObject("HEAD")
+	if op := rw.OperationInProgress(); op != "" {
+		done <- newResult(rw.RepoInfo.Name, SKIPPD, fmt.Sprintf("[%s]: [%s] %s in progress", rw.Branch, localHEAD, op)).describe(rw, oldBranch, localSHA)
+		return
+	}
+	dirty, err := rw.IsDirty()
+	if err != nil {
+		done <- failedWorkflow(rw, err.Error()).describe(rw, oldBranch, localSHA)
+		return
+	}
+	if dirty && !force {
+		done <- newResult(rw.RepoInfo.Name, SKIPPD, fmt.Sprintf("[%s]: [%s] local changes, use --force to stash them", rw.Branch, localHEAD)).describe(rw, oldBranch, localSHA)
+		return
+	}
+	remote, err := selectRemote(rw.Remotes)
 	if err != nil {
-		name <- &SearchResult{rw.RepoInfo.Name, err.Error()}
-		goto findEND
+		done <- failedWorkflow(rw, err.Error()).describe(rw, oldBranch, localSHA)
+		return
+	}
+	if err := updateRemote(rw, remote); err != nil {
+		done <- failedWorkflow(rw, "Error performing remote update: "+err.Error()).describe(rw, oldBranch, localSHA)
+		return
+	}
+	if !force {
+		branch, count, err := unpushedWork(rw, remote)
+		if err != nil {
+			done <- failedWorkflow(rw, err.Error()).describe(rw, oldBranch, localSHA)
+			return
+		}
+		if count > 0 {
+			done <- newResult(rw.RepoInfo.Name, SKIPPD, fmt.Sprintf("[%s]: [%s] %d unpushed commits on %s, use --force to sync anyway", rw.Branch, localHEAD, count, branch)).describe(rw, oldBranch, localSHA)
+			return
+		}
+	}
+	stash := ""
+	if dirty {
+		stash, err = rw.Stash(fmt.Sprintf("%s%s before sync", StashPrefix, rw.Branch))
+		if err != nil {
+			done <- failedWorkflow(rw, err.Error()).describe(rw, oldBranch, localSHA)
+			return
+		}
+	}
+	wfr := syncBase(rw, remote, strategy)
+	if interrupted() {
+		done <- interruptWorkflow(rw, wfr).describe(rw, oldBranch, localSHA)
+		return
+	}
+	if stash != "" {
+		wfr.Message += " (changes stashed)"
+	}
+	done <- wfr.describe(rw, oldBranch, localSHA)
+}
+
+// Finds commits that sync would leave behind: ones on the branch checked out,
+// or on the local base branch, that are not on the remote. Returns the branch
+// and how many there are.
+func unpushedWork(rw *RepoWorker, remote string) (string, int, error) {
+	branches := []string{}
+	if rw.Branch != "DETACHED_HEAD" {
+		branches = append(branches, rw.Branch)
+	}
+	masterBranch := baseBranchFor(rw.RepoInfo)
+	if rw.Branch != masterBranch {
+		if _, err := rw.RevParseFull("refs/heads/" + masterBranch); err == nil {
+			branches = append(branches, masterBranch)
+		}
 	}
 	for _, branch := range branches {
-		branch = strings.TrimPrefix(branch, "* ")
-		if branch == target {
-			name <- &SearchResult{rw.RepoInfo.Name, target}
-			goto findEND
+		count, err := rw.Unpushed("refs/heads/"+branch, remote)
+		if err != nil {
+			return "", 0, err
+		}
+		if count > 0 {
+			return branch, count, nil
+		}
+	}
+	return "", 0, nil
+}
+
+// Fetches the remote, unless running offline or it was fetched within the
+// fetch TTL, in which case the refs already fetched are used and the worker
+// notes how old they are
+func updateRemote(rw *RepoWorker, remote string) error {
+	path := rw.RepoInfo.Path
+	if !Offline && !fetches.Fresh(path, remote, FetchTTL) {
+		if err := rw.Update(remote); err != nil {
+			return err
+		}
+		if err := fetches.Record(path, remote, time.Now()); err != nil {
+			logf("Error saving the fetch cache: %s\n", err)
+		}
+		return nil
+	}
+	rw.Cached = true
+	rw.LastFetch = fetches.LastFetch(path, remote)
+	if rw.LastFetch.IsZero() {
+		rw.LastFetch, _ = rw.LastFetched()
+	}
+	return nil
+}
+
+// When the cached refs were fetched, for the JSON output, nil if fetched this
+// run or unknown
+func (rw *RepoWorker) fetchedAt() *time.Time {
+	if !rw.Cached || rw.LastFetch.IsZero() {
+		return nil
+	}
+	lastFetch := rw.LastFetch
+	return &lastFetch
+}
+
+// How old cached remote refs are, for marking results based on them
+func fetchAge(offline bool, lastFetch time.Time) string {
+	prefix := ""
+	if offline {
+		prefix = "offline, "
+	}
+	if lastFetch.IsZero() {
+		return prefix + "last fetch unknown"
+	}
+	age := time.Since(lastFetch)
+	switch {
+	case age < time.Minute:
+		return prefix + "fetched just now"
+	case age < time.Hour:
+		return fmt.Sprintf("%sfetched %dm ago", prefix, int(age.Minutes()))
+	case age < 48*time.Hour:
+		return fmt.Sprintf("%sfetched %dh ago", prefix, int(age.Hours()))
+	}
+	return fmt.Sprintf("%sfetched %dd ago", prefix, int(age.Hours()/24))
+}
+
+// Reports a failure as INTRPT instead when the run was interrupted or timed out
+func failedWorkflow(rw *RepoWorker, message string) *WorkFlowResult {
+	wfr := newResult(rw.RepoInfo.Name, FAILED, message)
+	if interrupted() {
+		return interruptWorkflow(rw, wfr)
+	}
+	return wfr
+}
+
+// Names the interrupt or timeout as the reason an error came about, if it did
+func interruptedErr(err error) error {
+	if interrupted() {
+		return fmt.Errorf("%s", interruptReason())
+	}
+	return err
+}
+
+// Aborts whatever an interrupted workflow left in progress and marks the repo as interrupted
+func interruptWorkflow(rw *RepoWorker, wfr *WorkFlowResult) *WorkFlowResult {
+	message := wfr.Message
+	actions, err := rw.Cleanup()
+	if err != nil {
+		actions = append(actions, "cleanup failed: "+err.Error())
+	}
+	if len(actions) > 0 {
+		message = fmt.Sprintf("%s (%s)", message, strings.Join(actions, ", "))
+	}
+	return newResult(rw.RepoInfo.Name, INTRPT, message)
+}
+
+// Pops the newest yeet stash that was made on the branch the repo is now on
+func restoreStash(rw *RepoWorker, wfr *WorkFlowResult) {
+	stashes, err := rw.YeetStashes()
+	if err != nil {
+		return
+	}
+	for _, stash := range stashes {
+		if stash.Branch != rw.Branch {
+			continue
 		}
+		if err := rw.StashPop(stash.Ref); err != nil {
+			wfr.Status = CNFLCT
+			wfr.Message += fmt.Sprintf(" (%s did not apply)", stash.Ref)
+			return
+		}
+		wfr.Message += " (stash restored)"
+		return
 	}
+}
 
+var shaPattern = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// The four ways a take can treat a repo, checked in this order
+type takeCase int
+
+const (
+	//CASE1: the target branch is the current branch
+	takeCurrent takeCase = iota + 1
+	//CASE2: the target branch exists locally
+	takeLocal
+	//CASE3: the target branch only exists remotely
+	takeRemote
+	//CASE4: the repo has no access to the target branch
+	takeMaster
+)
+
+func selectRemote(remotes []string) (string, error) {
 	if len(remotes) == 1 {
-		remote = remotes[0]
+		return remotes[0], nil
 	} else if slices.Contains(remotes, config.FCRemote) {
-		remote = config.FCRemote
-	} else {
-		name <- &SearchResult{rw.RepoInfo.Name, err.Error()}
-		goto findEND
+		return config.FCRemote, nil
 	}
+	return "", fmt.Errorf("Correct remote not found")
+}
 
-	err = rw.Update(remote)
-	if err != nil {
-		// name <- &SearchResult{rw.RepoInfo.Name, err.Error()}
-		goto findEND
+// The branch topics are rebased onto in a repo: an override from the config's
+// basebranches, keyed by project name or path, then the manifest's dest-branch,
+// then its revision if that names a branch, and finally masterbranch
+func baseBranchFor(info *RepoInfo) string {
+	if branch, ok := config.BaseBranches[info.Name]; ok {
+		return branch
+	}
+	if branch, ok := config.BaseBranches[info.RelPath]; ok {
+		return branch
+	}
+	for _, rev := range []string{info.DestBranch, info.Revision} {
+		if branch := branchFromRevision(rev); branch != "" {
+			return branch
+		}
 	}
+	return config.MasterBranch
+}
 
-	if slices.Contains(branches, fmtRemoteTarget) {
-		name <- &SearchResult{rw.RepoInfo.Name, fmtRemoteTarget}
+// Revisions can also be tags or commit hashes, which are no use as a base
+func branchFromRevision(rev string) string {
+	if strings.HasPrefix(rev, "refs/heads/") {
+		return strings.TrimPrefix(rev, "refs/heads/")
+	}
+	if rev == "" || strings.HasPrefix(rev, "refs/") || shaPattern.MatchString(rev) {
+		return ""
 	}
-findEND:
-	wg.Done()
+	return rev
 }
 
-func takeWorkflow(target string, init *RepoWorkerInitializer, done chan<- *WorkFlowResult) {
+// Picks the case for a repo and the target it applies to. Targets are tried in
+// order and the first one the repo has wins. The remote must already be updated.
+func selectTakeCase(targets []string, rw *RepoWorker, remote string) (takeCase, string, error) {
+	branches, err := rw.BranchList()
+	if err != nil {
+		return 0, "", err
+	}
+	for _, target := range targets {
+		if rw.Branch == target {
+			return takeCurrent, target, nil
+		}
+		if slices.Contains(branches, target) {
+			return takeLocal, target, nil
+		}
+		if slices.Contains(branches, fmt.Sprintf("remotes/%s/%s", remote, target)) {
+			return takeRemote, target, nil
+		}
+	}
+	return takeMaster, "", nil
+}
+
+// Works out what take would do to a repo without changing anything
+func planWorkflow(targets []string, init *RepoWorkerInitializer, opts *TakeOptions, done chan<- *WorkFlowResult) {
+	if interrupted() {
+		done <- newResult(init.RepoInfo.Name, INTRPT, "Not started")
+		return
+	}
 	rw, err := init.NewRepoWorker()
 	if err != nil {
-		panic(err)
+		done <- workerFailed(init.RepoInfo, err)
+		return
 	}
-	// Stash current changes on branch
-	_ = rw.Stash()
-	// Select remote
-	remotes := rw.Remotes
-	var remote string
-	if len(remotes) == 1 {
-		remote = remotes[0]
-	} else if slices.Contains(remotes, config.FCRemote) {
-		remote = config.FCRemote
-	} else {
-		done <- &WorkFlowResult{rw.RepoInfo.Name, FAILED, "Correct remote not found"}
+	remote, err := selectRemote(rw.Remotes)
+	if err != nil {
+		done <- failedWorkflow(rw, err.Error()).describe(rw, rw.Branch, "")
+		return
+	}
+	if err = updateRemote(rw, remote); err != nil {
+		done <- failedWorkflow(rw, fmt.Sprintf("Error performing remote update: %s", err.Error())).describe(rw, rw.Branch, "")
 		return
 	}
+	if changes := opts.gerritChanges[rw.RepoInfo.Name]; len(changes) > 0 {
+		done <- planChanges(changes, targets[0], rw, remote, opts.Strategy)
+		return
+	} else if opts.gerritChanges != nil {
+		targets = nil
+	}
+	tc, target, err := selectTakeCase(targets, rw, remote)
+	if err != nil {
+		done <- failedWorkflow(rw, fmt.Sprintf("Error getting branch names: %s", err.Error())).describe(rw, rw.Branch, "")
+		return
+	}
+	masterBranch := baseBranchFor(rw.RepoInfo)
+	remoteTarget := fmt.Sprintf("%s/%s", remote, masterBranch)
+	remoteMasterBranch := fmt.Sprintf("%s/%s", remote, masterBranch)
+	prevBranch := rw.Branch
+	if prevBranch == "" {
+		prevBranch = "DETACHED_HEAD"
+	}
+	steps := make([]string, 0)
+	if dirty, _ := rw.IsDirty(); dirty {
+		steps = append(steps, "stash")
+	}
+	// Mirrors the integrations done by take for each case
+	rebaseSteps := func(branch string) {
+		localHEAD, _ := rw.RevParseObject(branch)
+		remoteHEAD, err := rw.RevParseUpstream(branch)
+		if err != nil {
+			steps = append(steps, "stop (no remote)")
+			return
+		}
+		if localHEAD != remoteHEAD {
+			steps = append(steps, integrateStep(opts.Strategy, remoteTarget))
+		}
+		if branch != masterBranch {
+			steps = append(steps, integrateStep(opts.Strategy, remoteMasterBranch))
+		}
+	}
+	var message string
+	switch tc {
+	case takeCurrent:
+		message = fmt.Sprintf("CASE1 [%s]", target)
+		rebaseSteps(target)
+	case takeLocal:
+		message = fmt.Sprintf("CASE2 [%s]->[%s]", prevBranch, target)
+		steps = append(steps, "checkout "+target)
+		rebaseSteps(target)
+	case takeRemote:
+		message = fmt.Sprintf("CASE3 [%s]->[%s]", prevBranch, target)
+		steps = append(steps, fmt.Sprintf("checkout -B %s --track %s/%s", target, remote, target))
+		steps = append(steps, integrateStep(opts.Strategy, remoteMasterBranch))
+	case takeMaster:
+		if rw.Branch == masterBranch {
+			message = fmt.Sprintf("CASE4 [%s]", masterBranch)
+		} else {
+			message = fmt.Sprintf("CASE4 [%s]->[%s]", prevBranch, masterBranch)
+			steps = append(steps, "checkout "+masterBranch)
+		}
+		// git creates a missing base branch from the remote one on checkout
+		if _, err := rw.RevParseFull("refs/heads/" + masterBranch); err != nil {
+			steps[len(steps)-1] = fmt.Sprintf("checkout %s (from %s)", masterBranch, remoteMasterBranch)
+			break
+		}
+		localHEAD, _ := rw.RevParseObject(masterBranch)
+		remoteHEAD, err := rw.RevParseUpstream(masterBranch)
+		if err != nil {
+			steps = append(steps, "stop (no remote)")
+		} else if localHEAD != remoteHEAD {
+			steps = append(steps, integrateStep(opts.Strategy, remoteMasterBranch))
+		}
+	}
+	if len(steps) == 0 {
+		steps = append(steps, "nothing to do")
+	}
+	wfr := newResult(rw.RepoInfo.Name, DRYRUN, fmt.Sprintf("%s: %s", message, strings.Join(steps, "; ")))
+	localSHA, _ := rw.RevParseFull("HEAD")
+	wfr.describe(rw, rw.Branch, localSHA)
+	// Nothing was changed, so report where take would leave the repo
+	switch tc {
+	case takeCurrent, takeLocal, takeRemote:
+		wfr.NewBranch = target
+	case takeMaster:
+		wfr.NewBranch = masterBranch
+	}
+	wfr.NewSHA = ""
+	done <- wfr
+}
+
+func take(targets []string, rw *RepoWorker, strategy string) *WorkFlowResult {
+	remote, err := selectRemote(rw.Remotes)
+	if err != nil {
+		return newResult(rw.RepoInfo.Name, FAILED, err.Error())
+	}
 	var wfr *WorkFlowResult
 	var message string
 	var localHEAD string
 	var remoteHEAD string
-	var branches []string
-	remoteTarget := fmt.Sprintf("%s/%s", remote, config.MasterBranch)
-	fmtRemoteTarget := fmt.Sprintf("remotes/%s/%s", remote, target)
-	remoteMasterBranch := fmt.Sprintf("%s/%s", remote, config.MasterBranch)
+	masterBranch := baseBranchFor(rw.RepoInfo)
+	remoteTarget := fmt.Sprintf("%s/%s", remote, masterBranch)
+	remoteMasterBranch := fmt.Sprintf("%s/%s", remote, masterBranch)
 
 	// Update info from remote
-	if err = rw.Update(remote); err != nil {
-		done <- &WorkFlowResult{rw.RepoInfo.Name, FAILED, fmt.Sprintf("Error performing remote update: %s", err.Error())}
-		goto takeEND
+	if err = updateRemote(rw, remote); err != nil {
+		return newResult(rw.RepoInfo.Name, FAILED, fmt.Sprintf("Error performing remote update: %s", err.Error()))
+	}
+
+	tc, target, err := selectTakeCase(targets, rw, remote)
+	if err != nil {
+		return newResult(rw.RepoInfo.Name, FAILED, fmt.Sprintf("Error getting branch names: %s", err.Error()))
 	}
 
 	//CASE1: elif the target branch is the current branch
-	if rw.Branch == target {
+	if tc == takeCurrent {
 		localHEAD, _ = rw.RevParseObject("HEAD")
 		remoteHEAD, err = rw.RevParseUpstream(target)
 		if err != nil {
-			wfr = &WorkFlowResult{rw.RepoInfo.Name, CNFLCT, fmt.Sprintf("[%s]: [%s] (no remote)", rw.Branch, localHEAD)}
-			goto exitcase1
+			wfr = newResult(rw.RepoInfo.Name, CNFLCT, fmt.Sprintf("[%s]: [%s] (no remote)", target, localHEAD))
+			return wfr
 		}
 		if localHEAD == remoteHEAD {
-			wfr = &WorkFlowResult{rw.RepoInfo.Name, PASSED, fmt.Sprintf("[%s]: [%s]", rw.Branch, localHEAD)}
-		} else if rebaseSuccess, err := rw.Rebase(remoteTarget); err != nil {
-			wfr = &WorkFlowResult{rw.RepoInfo.Name, FAILED, err.Error()}
-			goto exitcase1
+			wfr = newResult(rw.RepoInfo.Name, PASSED, fmt.Sprintf("[%s]: [%s]", target, localHEAD))
+		} else if rebaseSuccess, err := rw.Integrate(strategy, remoteTarget); err != nil {
+			wfr = newResult(rw.RepoInfo.Name, FAILED, err.Error())
+			return wfr
 		} else if !rebaseSuccess {
-			wfr = &WorkFlowResult{rw.RepoInfo.Name, CNFLCT, fmt.Sprintf("[%s]: [%s]", rw.Branch, localHEAD)}
-			goto exitcase1
+			wfr = newResult(rw.RepoInfo.Name, CNFLCT, fmt.Sprintf("[%s]: [%s]", target, localHEAD))
+			return wfr
 		} else {
-			wfr = &WorkFlowResult{rw.RepoInfo.Name, PASSED, fmt.Sprintf("[%s]: [%s]->[%s]", rw.Branch, localHEAD, remoteHEAD)}
+			wfr = newResult(rw.RepoInfo.Name, PASSED, fmt.Sprintf("[%s]: [%s]->[%s]", target, localHEAD, remoteHEAD))
 		}
-		// If currently on master, no need to rebase on master
-		if rw.Branch == config.MasterBranch {
-			goto exitcase1
+		// If currently on master, no need to rebase on master. The name is checked
+		// rather than rw.Branch as a merge leaves HEAD detached
+		if target == masterBranch {
+			return wfr
 		}
-		if rebaseSuccess, err := rw.Rebase(remoteMasterBranch); err != nil {
-			wfr = &WorkFlowResult{rw.RepoInfo.Name, FAILED, err.Error()}
+		if rebaseSuccess, err := rw.Integrate(strategy, remoteMasterBranch); err != nil {
+			wfr = newResult(rw.RepoInfo.Name, FAILED, err.Error())
 		} else if !rebaseSuccess {
 			wfr.Status = CNFLCT
 		} else {
 			newLocalHEAD, _ := rw.RevParseObject("HEAD")
-			wfr.Message = fmt.Sprintf("[%s]: [%s]->[%s]", rw.Branch, localHEAD, newLocalHEAD)
+			wfr.Message = fmt.Sprintf("[%s]: [%s]->[%s]", target, localHEAD, newLocalHEAD)
 		}
-	exitcase1:
-		done <- wfr
-		return
-	}
-
-	//load branches
-	branches, err = rw.BranchList()
-	if err != nil {
-		done <- &WorkFlowResult{rw.RepoInfo.Name, FAILED, fmt.Sprintf("Error getting branch names: %s", err.Error())}
-		goto takeEND
+		return wfr
 	}
 
 	//CASE2: elif the target branch exists locally
-	if slices.Contains(branches, target) {
+	if tc == takeLocal {
 		prevBranch := rw.Branch
 		if prevBranch == "" {
 			prevBranch = "DETACHED_HEAD"
 		}
 		if err := rw.CheckoutLocal(target); err != nil {
-			wfr = &WorkFlowResult{rw.RepoInfo.Name, FAILED, fmt.Sprintf("Error checking out %s: %s", target, err.Error())}
-			goto exitcase2
+			wfr = newResult(rw.RepoInfo.Name, FAILED, fmt.Sprintf("Error checking out %s: %s", target, err.Error()))
+			return wfr
 		}
 		message = fmt.Sprintf("[%s]->[%s]", prevBranch, target)
 		localHEAD, _ = rw.RevParseObject("HEAD")
 		remoteHEAD, err = rw.RevParseUpstream(target)
 		if err != nil {
-			wfr = &WorkFlowResult{rw.RepoInfo.Name, CNFLCT, fmt.Sprintf("%s: [%s] (no remote)", message, localHEAD)}
-			goto exitcase2
+			wfr = newResult(rw.RepoInfo.Name, CNFLCT, fmt.Sprintf("%s: [%s] (no remote)", message, localHEAD))
+			return wfr
 		}
 		if localHEAD == remoteHEAD {
-			wfr = &WorkFlowResult{rw.RepoInfo.Name, PASSED, fmt.Sprintf("%s: [%s]", message, localHEAD)}
-		} else if rebaseSuccess, err := rw.Rebase(remoteTarget); err != nil {
-			wfr = &WorkFlowResult{rw.RepoInfo.Name, FAILED, err.Error()}
-			goto exitcase2
+			wfr = newResult(rw.RepoInfo.Name, PASSED, fmt.Sprintf("%s: [%s]", message, localHEAD))
+		} else if rebaseSuccess, err := rw.Integrate(strategy, remoteTarget); err != nil {
+			wfr = newResult(rw.RepoInfo.Name, FAILED, err.Error())
+			return wfr
 		} else if !rebaseSuccess {
-			wfr = &WorkFlowResult{rw.RepoInfo.Name, CNFLCT, fmt.Sprintf("%s: [%s]", message, localHEAD)}
-			goto exitcase2
+			wfr = newResult(rw.RepoInfo.Name, CNFLCT, fmt.Sprintf("%s: [%s]", message, localHEAD))
+			return wfr
 		} else {
 			newLocalHEAD, _ := rw.RevParseObject("HEAD")
-			wfr = &WorkFlowResult{rw.RepoInfo.Name, PASSED, fmt.Sprintf("%s: [%s]->[%s]", message, localHEAD, newLocalHEAD)}
+			wfr = newResult(rw.RepoInfo.Name, PASSED, fmt.Sprintf("%s: [%s]->[%s]", message, localHEAD, newLocalHEAD))
 		}
 		// If currently on master, no need to rebase on master
-		if rw.Branch == config.MasterBranch {
-			goto exitcase2
+		if target == masterBranch {
+			return wfr
 		}
-		if rebaseSuccess, err := rw.Rebase(remoteMasterBranch); err != nil {
-			wfr = &WorkFlowResult{rw.RepoInfo.Name, FAILED, err.Error()}
+		if rebaseSuccess, err := rw.Integrate(strategy, remoteMasterBranch); err != nil {
+			wfr = newResult(rw.RepoInfo.Name, FAILED, err.Error())
 		} else if !rebaseSuccess {
 			wfr.Status = CNFLCT
 		} else {
 			newLocalHEAD, _ := rw.RevParseObject("HEAD")
-			wfr = &WorkFlowResult{rw.RepoInfo.Name, PASSED, fmt.Sprintf("%s: [%s]->[%s]", message, localHEAD, newLocalHEAD)}
+			wfr = newResult(rw.RepoInfo.Name, PASSED, fmt.Sprintf("%s: [%s]->[%s]", message, localHEAD, newLocalHEAD))
 		}
-	exitcase2:
-		done <- wfr
-		return
+		return wfr
 	}
 
 	//CASE3: elif the target branch only exists remotely
-	if slices.Contains(branches, fmtRemoteTarget) {
+	if tc == takeRemote {
 		prevBranch := rw.Branch
 		if prevBranch == "" {
 			prevBranch = "DETACHED_HEAD"
 		}
 		if err := rw.CheckoutRemote(target, remote); err != nil {
-			wfr = &WorkFlowResult{rw.RepoInfo.Name, FAILED, fmt.Sprintf("Cannot checkout remote branch %s: %s", target, err.Error())}
-			goto exitcase3
+			wfr = newResult(rw.RepoInfo.Name, FAILED, fmt.Sprintf("Cannot checkout remote branch %s: %s", target, err.Error()))
+			return wfr
 		}
 		message = fmt.Sprintf("[%s]->[%s]", prevBranch, target)
 		localHEAD, _ = rw.RevParseObject("HEAD")
-		if rebaseSuccess, err := rw.Rebase(remoteMasterBranch); err != nil {
-			wfr = &WorkFlowResult{rw.RepoInfo.Name, FAILED, err.Error()}
+		if rebaseSuccess, err := rw.Integrate(strategy, remoteMasterBranch); err != nil {
+			wfr = newResult(rw.RepoInfo.Name, FAILED, err.Error())
 		} else if !rebaseSuccess {
-			wfr = &WorkFlowResult{rw.RepoInfo.Name, CNFLCT, fmt.Sprintf("%s: [%s]", message, localHEAD)}
+			wfr = newResult(rw.RepoInfo.Name, CNFLCT, fmt.Sprintf("%s: [%s]", message, localHEAD))
 		} else {
 			newLocalHEAD, _ := rw.RevParseObject("HEAD")
-			wfr = &WorkFlowResult{rw.RepoInfo.Name, PASSED, fmt.Sprintf("%s: [%s]->[%s]", message, localHEAD, newLocalHEAD)}
+			wfr = newResult(rw.RepoInfo.Name, PASSED, fmt.Sprintf("%s: [%s]->[%s]", message, localHEAD, newLocalHEAD))
 		}
-	exitcase3:
-		done <- wfr
-		return
+		return wfr
 	}
 
 	//CASE4: elif the repo has no access to the target branch
-	if true {
-		prevBranch := rw.Branch
-		if prevBranch == "" {
-			prevBranch = "DETACHED_HEAD"
+	return syncBase(rw, remote, strategy)
+}
+
+// Checks out the base branch and brings it up to the remote, which must have
+// been fetched already
+func syncBase(rw *RepoWorker, remote string, strategy string) *WorkFlowResult {
+	var wfr *WorkFlowResult
+	var message string
+	masterBranch := baseBranchFor(rw.RepoInfo)
+	remoteMasterBranch := fmt.Sprintf("%s/%s", remote, masterBranch)
+	prevBranch := rw.Branch
+	if prevBranch == "" {
+		prevBranch = "DETACHED_HEAD"
+	}
+	if rw.Branch == masterBranch {
+		message = fmt.Sprintf("[%s]", masterBranch)
+	} else {
+		if err := rw.CheckoutLocal(masterBranch); err != nil {
+			wfr = newResult(rw.RepoInfo.Name, FAILED, fmt.Sprintf("Error checking out %s: %s", masterBranch, err.Error()))
+			return wfr
 		}
-		if rw.Branch == config.MasterBranch {
-			message = fmt.Sprintf("[%s]", config.MasterBranch)
-		} else {
-			if err := rw.CheckoutLocal(config.MasterBranch); err != nil {
-				wfr = &WorkFlowResult{rw.RepoInfo.Name, FAILED, fmt.Sprintf("Error checking out %s: %s", target, err.Error())}
-				goto exitcase4
-			}
-			message = fmt.Sprintf("[%s]->[%s]", prevBranch, config.MasterBranch)
+		message = fmt.Sprintf("[%s]->[%s]", prevBranch, masterBranch)
+	}
+
+	localHEAD, _ := rw.RevParseObject("HEAD")
+	remoteHEAD, err := rw.RevParseUpstream(masterBranch)
+	if err != nil {
+		wfr = newResult(rw.RepoInfo.Name, CNFLCT, fmt.Sprintf("Cannot update to remote: %s", err.Error()))
+		return wfr
+	}
+	if localHEAD == remoteHEAD {
+		return newResult(rw.RepoInfo.Name, PASSED, fmt.Sprintf("%s: [%s]", message, localHEAD))
+	}
+	// A throwaway merge is for topics, the base branch itself is merged into
+	integrate := func() (bool, error) { return rw.Integrate(strategy, remoteMasterBranch) }
+	if strategy == StrategyMerge {
+		integrate = func() (bool, error) { return rw.Merge(remoteMasterBranch) }
+	}
+	if rebaseSuccess, err := integrate(); err != nil {
+		wfr = newResult(rw.RepoInfo.Name, FAILED, err.Error())
+	} else if !rebaseSuccess {
+		wfr = newResult(rw.RepoInfo.Name, CNFLCT, fmt.Sprintf("%s: [%s]", message, localHEAD))
+	} else {
+		newLocalHEAD, _ := rw.RevParseObject("HEAD")
+		wfr = newResult(rw.RepoInfo.Name, PASSED, fmt.Sprintf("%s: [%s]->[%s]", message, localHEAD, newLocalHEAD))
+	}
+	return wfr
+}
+
+// Integrates each Gerrit change onto the base branch, or onto the change before
+// it in the project, leaving branch checked out at the last one. Returns the
+// changes left after one that stopped on a conflict.
+func takeChanges(changes []*GerritChange, branch string, rw *RepoWorker, strategy string) (*WorkFlowResult, []*GerritChange) {
+	remote, err := selectRemote(rw.Remotes)
+	if err != nil {
+		return newResult(rw.RepoInfo.Name, FAILED, err.Error()), nil
+	}
+	if err = updateRemote(rw, remote); err != nil {
+		return newResult(rw.RepoInfo.Name, FAILED, fmt.Sprintf("Error performing remote update: %s", err.Error())), nil
+	}
+	refs := make([]string, 0, len(changes))
+	for _, change := range changes {
+		refs = append(refs, change.Ref)
+	}
+	if err := rw.FetchRefs(remote, refs...); err != nil {
+		return newResult(rw.RepoInfo.Name, FAILED, fmt.Sprintf("Error fetching changes: %s", err.Error())), nil
+	}
+	prevBranch := rw.Branch
+	if prevBranch == "" {
+		prevBranch = "DETACHED_HEAD"
+	}
+	message := fmt.Sprintf("[%s]->[%s]", prevBranch, branch)
+	localHEAD, _ := rw.RevParseObject("HEAD")
+	onto := fmt.Sprintf("%s/%s", remote, baseBranchFor(rw.RepoInfo))
+	return applyChanges(changes, branch, rw, strategy, onto, message, localHEAD)
+}
+
+// Integrates changes one after another, the first onto onto. Shared by take
+// and by continue, which picks up after the change that stopped.
+func applyChanges(changes []*GerritChange, branch string, rw *RepoWorker, strategy string, onto string, message string, localHEAD string) (*WorkFlowResult, []*GerritChange) {
+	taken := make([]string, 0, len(changes))
+	for i, change := range changes {
+		if integrated, err := integrateChange(rw, strategy, branch, change, onto); err != nil {
+			return newResult(rw.RepoInfo.Name, FAILED, fmt.Sprintf("Error taking change %s: %s", change, err.Error())), nil
+		} else if !integrated {
+			return newResult(rw.RepoInfo.Name, CNFLCT, fmt.Sprintf("%s: [%s] (change %s conflicts)", message, localHEAD, change)), changes[i+1:]
+		}
+		onto, _ = rw.RevParseFull("HEAD")
+		taken = append(taken, change.String())
+	}
+	newLocalHEAD, _ := rw.RevParseObject("HEAD")
+	if len(taken) > 0 {
+		message = fmt.Sprintf("%s: [%s]->[%s] (%s)", message, localHEAD, newLocalHEAD, strings.Join(taken, ", "))
+	} else {
+		message = fmt.Sprintf("%s: [%s]->[%s]", message, localHEAD, newLocalHEAD)
+	}
+	return newResult(rw.RepoInfo.Name, PASSED, message), nil
+}
+
+// Puts a single Gerrit change on top of onto in branch
+func integrateChange(rw *RepoWorker, strategy string, branch string, change *GerritChange, onto string) (bool, error) {
+	switch strategy {
+	case StrategyMerge, StrategyFFOnly:
+		// The change is kept as uploaded and onto brought into it
+		if err := rw.CheckoutAt(branch, change.Revision); err != nil {
+			return false, err
+		}
+		return rw.Integrate(strategy, onto)
+	case StrategyCherryPick:
+		if err := rw.CheckoutAt(branch, onto); err != nil {
+			return false, err
 		}
+		return rw.CherryPick(change.Revision)
+	}
+	if err := rw.CheckoutAt(branch, change.Revision); err != nil {
+		return false, err
+	}
+	// Only the change itself is replayed, not the history it was uploaded on
+	return rw.RebaseOnto(onto, change.Revision+"~1")
+}
 
-		localHEAD, _ = rw.RevParseObject("HEAD")
-		remoteHEAD, err = rw.RevParseUpstream(config.MasterBranch)
+// How a dry run describes bringing a branch onto onto
+func integrateStep(strategy string, onto string) string {
+	switch strategy {
+	case StrategyMerge:
+		return "merge " + onto
+	case StrategyFFOnly:
+		return "fast-forward to " + onto
+	case StrategyCherryPick:
+		return "cherry-pick onto " + onto
+	}
+	return "rebase " + onto
+}
+
+// The dry run counterpart of takeChanges
+func planChanges(changes []*GerritChange, branch string, rw *RepoWorker, remote string, strategy string) *WorkFlowResult {
+	prevBranch := rw.Branch
+	if prevBranch == "" {
+		prevBranch = "DETACHED_HEAD"
+	}
+	steps := make([]string, 0)
+	if dirty, _ := rw.IsDirty(); dirty {
+		steps = append(steps, "stash")
+	}
+	refs := make([]string, 0, len(changes))
+	for _, change := range changes {
+		refs = append(refs, change.Ref)
+	}
+	steps = append(steps, "fetch "+strings.Join(refs, " "))
+	onto := fmt.Sprintf("%s/%s", remote, baseBranchFor(rw.RepoInfo))
+	for _, change := range changes {
+		steps = append(steps, fmt.Sprintf("%s: %s", change, integrateStep(strategy, onto)))
+		onto = change.String()
+	}
+	wfr := newResult(rw.RepoInfo.Name, DRYRUN, fmt.Sprintf("GERRIT [%s]->[%s]: %s", prevBranch, branch, strings.Join(steps, "; ")))
+	localSHA, _ := rw.RevParseFull("HEAD")
+	wfr.describe(rw, rw.Branch, localSHA)
+	wfr.NewBranch = branch
+	wfr.NewSHA = ""
+	return wfr
+}
+
+// Reported when a repo cannot be opened at all, as INTRPT if that was down to
+// an interrupt or timeout since nothing was changed yet
+func workerFailed(info *RepoInfo, err error) *WorkFlowResult {
+	wfr := newResult(info.Name, FAILED, err.Error())
+	if interrupted() {
+		wfr.Status = INTRPT
+	}
+	wfr.Path = info.Path
+	wfr.Error = err.Error()
+	return wfr
+}
+
+func newResult(name string, status Status, message string) *WorkFlowResult {
+	return &WorkFlowResult{RepoName: name, Status: status, Message: message}
+}
+
+// Fills in the fields of a result that scripts read instead of the message
+func (wfr *WorkFlowResult) describe(rw *RepoWorker, oldBranch string, oldSHA string) *WorkFlowResult {
+	wfr.Path = rw.RepoInfo.Path
+	wfr.OldBranch = oldBranch
+	wfr.OldSHA = oldSHA
+	wfr.NewBranch = rw.Branch
+	wfr.NewSHA, _ = rw.RevParseFull("HEAD")
+	if wfr.Status == FAILED && wfr.Error == "" {
+		wfr.Error = wfr.Message
+	}
+	if rw.Cached && !wfr.Cached {
+		wfr.Cached, wfr.Offline = true, Offline
+		wfr.FetchedAt = rw.fetchedAt()
+		wfr.Message += fmt.Sprintf(" (%s)", fetchAge(Offline, rw.LastFetch))
+	}
+	return wfr
+}
+
+func newJournalEntry(rw *RepoWorker, branches ...string) *JournalEntry {
+	head, _ := rw.RevParseFull("HEAD")
+	entry := &JournalEntry{
+		Name:     rw.RepoInfo.Name,
+		Path:     rw.RepoInfo.Path,
+		Branch:   rw.Branch,
+		HEAD:     head,
+		Branches: make(map[string]string),
+	}
+	for _, branch := range branches {
+		if hash, err := rw.RevParseFull("refs/heads/" + branch); err == nil {
+			entry.Branches[branch] = hash
+		}
+	}
+	return entry
+}
+
+func undoWorkflow(entry *JournalEntry, done chan<- *WorkFlowResult) {
+	if interrupted() {
+		done <- newResult(entry.Name, INTRPT, "Not started")
+		return
+	}
+	init := &RepoWorkerInitializer{&RepoInfo{Path: entry.Path, Name: entry.Name}}
+	rw, err := init.NewRepoWorker()
+	if err != nil {
+		done <- workerFailed(init.RepoInfo, err)
+		return
+	}
+	oldBranch := rw.Branch
+	oldSHA, _ := rw.RevParseFull("HEAD")
+	wfr := undo(entry, rw)
+	if interrupted() {
+		wfr = interruptWorkflow(rw, wfr)
+	}
+	done <- wfr.describe(rw, oldBranch, oldSHA)
+}
+
+func undo(entry *JournalEntry, rw *RepoWorker) *WorkFlowResult {
+	if entry.HEAD == "" {
+		return newResult(entry.Name, FAILED, "No original commit recorded")
+	}
+	if op := rw.OperationInProgress(); op != "" {
+		if err := rw.AbortOperation(op); err != nil {
+			return newResult(entry.Name, FAILED, err.Error())
+		}
+	}
+	// Keep anything changed since the take. The checkout below is forced, so
+	// a tree that cannot be stashed must not get that far
+	if _, err := rw.Stash(fmt.Sprintf("%s%s before undo", StashPrefix, rw.Branch)); err != nil {
+		return newResult(entry.Name, FAILED, fmt.Sprintf("[%s]: cannot stash local changes, leaving the repo alone: %s", rw.Branch, err.Error()))
+	}
+	prevBranch := rw.Branch
+	if err := rw.CheckoutAt(entry.Branch, entry.HEAD); err != nil {
+		return newResult(entry.Name, FAILED, fmt.Sprintf("Error restoring %s: %s", entry.Branch, err.Error()))
+	}
+	for branch, hash := range entry.Branches {
+		if branch == entry.Branch {
+			continue
+		}
+		if err := rw.ResetBranch(branch, hash); err != nil {
+			return newResult(entry.Name, FAILED, fmt.Sprintf("Error restoring %s: %s", branch, err.Error()))
+		}
+	}
+	localHEAD, _ := rw.RevParseObject("HEAD")
+	message := fmt.Sprintf("[%s]->[%s]: [%s]", prevBranch, entry.Branch, localHEAD)
+	if entry.StashRef != "" {
+		if err := rw.StashApply(entry.StashRef); err != nil {
+			return newResult(entry.Name, CNFLCT, message+" (stash not restored)")
+		}
+		_ = rw.StashDropRef(entry.StashRef)
+		message += " (stash restored)"
+	}
+	return newResult(entry.Name, PASSED, message)
+}
+
+// Finishes a take left stopped on a conflict by --keep-conflicts
+func continueWorkflow(entry *JournalEntry, info *RepoInfo, journal *Journal, done chan<- *WorkFlowResult) {
+	if interrupted() {
+		done <- newResult(entry.Name, INTRPT, "Not started")
+		return
+	}
+	init := &RepoWorkerInitializer{info}
+	rw, err := init.NewRepoWorker()
+	if err != nil {
+		done <- workerFailed(init.RepoInfo, err)
+		return
+	}
+	rw.KeepConflicts = true
+	oldBranch := rw.Branch
+	oldSHA, _ := rw.RevParseFull("HEAD")
+	wfr, remaining := continueTake(entry.Pending, rw)
+	if interrupted() {
+		done <- interruptWorkflow(rw, wfr).describe(rw, oldBranch, oldSHA)
+		return
+	}
+	if wfr.Status == CNFLCT && rw.OperationInProgress() != "" {
+		wfr.Conflict = rw.Conflict
+		pending := *entry.Pending
+		pending.Changes = remaining
+		// A pick that stopped again on its next commit keeps its starting point
+		if rw.PickedFrom != "" {
+			pending.Head = rw.PickedFrom
+		}
+		_ = journal.SetPending(entry, &pending)
+	} else {
+		_ = journal.SetPending(entry, nil)
+	}
+	done <- wfr.describe(rw, oldBranch, oldSHA)
+}
+
+func continueTake(pending *PendingTake, rw *RepoWorker) (*WorkFlowResult, []*GerritChange) {
+	localHEAD, _ := rw.RevParseObject("HEAD")
+	op := rw.OperationInProgress()
+	if op != "" {
+		paths, err := rw.UnmergedPaths()
 		if err != nil {
-			wfr = &WorkFlowResult{rw.RepoInfo.Name, CNFLCT, fmt.Sprintf("Cannot update to remote: %s", err.Error())}
-			goto exitcase4
+			return newResult(rw.RepoInfo.Name, FAILED, err.Error()), pending.Changes
 		}
-		if localHEAD == remoteHEAD {
-			wfr = &WorkFlowResult{rw.RepoInfo.Name, PASSED, fmt.Sprintf("%s: [%s]", message, localHEAD)}
-		} else if rebaseSuccess, err := rw.Rebase(remoteMasterBranch); err != nil {
-			wfr = &WorkFlowResult{rw.RepoInfo.Name, FAILED, err.Error()}
-		} else if !rebaseSuccess {
-			wfr = &WorkFlowResult{rw.RepoInfo.Name, CNFLCT, fmt.Sprintf("%s: [%s]", message, localHEAD)}
-		} else {
-			newLocalHEAD, _ := rw.RevParseObject("HEAD")
-			wfr = &WorkFlowResult{rw.RepoInfo.Name, PASSED, fmt.Sprintf("%s: [%s]->[%s]", message, localHEAD, newLocalHEAD)}
+		if len(paths) > 0 {
+			return newResult(rw.RepoInfo.Name, CNFLCT, fmt.Sprintf("[%s]: unresolved conflicts in %s", op, strings.Join(paths, ", "))), pending.Changes
+		}
+		if continued, err := rw.ContinueOperation(op); err != nil {
+			return newResult(rw.RepoInfo.Name, FAILED, err.Error()), pending.Changes
+		} else if !continued {
+			return newResult(rw.RepoInfo.Name, CNFLCT, fmt.Sprintf("[%s]: stopped on another conflict", op)), pending.Changes
 		}
-	exitcase4:
-		done <- wfr
+	}
+	var wfr *WorkFlowResult
+	var remaining []*GerritChange
+	if pending.Branch != "" {
+		head, _ := rw.RevParseFull("HEAD")
+		wfr, remaining = applyChanges(pending.Changes, pending.Branch, rw, pending.Strategy, head, fmt.Sprintf("[%s]", rw.Branch), localHEAD)
+	} else if pending.Strategy == StrategyMerge {
+		// The merge was built on a detached HEAD, which take would leave for the
+		// topic branch, so only the merge into the base branch is done again
+		wfr = continueMerge(rw)
+	} else {
+		// The repo is now on top of its base, so running the take again only
+		// finishes any steps after the one that stopped
+		wfr = take(pending.Targets, rw, pending.Strategy)
+	}
+	if op != "" && wfr.Status == PASSED {
+		wfr.Message += fmt.Sprintf(" (%s continued)", op)
+	}
+	return wfr, remaining
+}
+
+// Merges the detached HEAD left by a continued merge into the base branch. If
+// the merge that stopped was already the one into the base this changes nothing.
+func continueMerge(rw *RepoWorker) *WorkFlowResult {
+	remote, err := selectRemote(rw.Remotes)
+	if err != nil {
+		return newResult(rw.RepoInfo.Name, FAILED, err.Error())
+	}
+	onto := fmt.Sprintf("%s/%s", remote, baseBranchFor(rw.RepoInfo))
+	localHEAD, _ := rw.RevParseObject("HEAD")
+	if merged, err := rw.Integrate(StrategyMerge, onto); err != nil {
+		return newResult(rw.RepoInfo.Name, FAILED, err.Error())
+	} else if !merged {
+		return newResult(rw.RepoInfo.Name, CNFLCT, fmt.Sprintf("[%s]: [%s]", rw.Branch, localHEAD))
+	}
+	newLocalHEAD, _ := rw.RevParseObject("HEAD")
+	return newResult(rw.RepoInfo.Name, PASSED, fmt.Sprintf("[%s]: [%s]->[%s]", rw.Branch, localHEAD, newLocalHEAD))
+}
+
+// Aborts a rebase, merge or cherry-pick left open by take --keep-conflicts
+func abortWorkflow(entry *JournalEntry, journal *Journal, done chan<- *WorkFlowResult) {
+	if interrupted() {
+		done <- newResult(entry.Name, INTRPT, "Not started")
+		return
+	}
+	init := &RepoWorkerInitializer{&RepoInfo{Path: entry.Path, Name: entry.Name}}
+	rw, err := init.NewRepoWorker()
+	if err != nil {
+		done <- workerFailed(init.RepoInfo, err)
+		return
+	}
+	oldBranch := rw.Branch
+	oldSHA, _ := rw.RevParseFull("HEAD")
+	op := rw.OperationInProgress()
+	if op == "" {
+		_ = journal.SetPending(entry, nil)
+		done <- newResult(entry.Name, PASSED, fmt.Sprintf("[%s]: nothing in progress", rw.Branch)).describe(rw, oldBranch, oldSHA)
+		return
+	}
+	// A take merge stops on a detached HEAD with the topic as MERGE_HEAD
+	topic := ""
+	if op == "merge" && rw.Branch == "DETACHED_HEAD" {
+		topic, _ = rw.RevParseFull("MERGE_HEAD")
+	}
+	if err := rw.AbortOperation(op); err != nil {
+		done <- failedWorkflow(rw, err.Error()).describe(rw, oldBranch, oldSHA)
 		return
 	}
+	// Put the topic back as a take without --keep-conflicts would have
+	if topic != "" {
+		if err := restoreTopic(rw, entry.Pending, topic); err != nil {
+			done <- failedWorkflow(rw, err.Error()).describe(rw, oldBranch, oldSHA)
+			return
+		}
+	} else if op == "cherry-pick" && entry.Pending != nil && entry.Pending.Head != "" {
+		// The abort leaves the branch at the base it was being picked onto
+		if err := rw.CheckoutAt(rw.Branch, entry.Pending.Head); err != nil {
+			done <- failedWorkflow(rw, err.Error()).describe(rw, oldBranch, oldSHA)
+			return
+		}
+	}
+	_ = journal.SetPending(entry, nil)
+	localHEAD, _ := rw.RevParseObject("HEAD")
+	done <- newResult(entry.Name, PASSED, fmt.Sprintf("[%s]: [%s] (aborted %s)", rw.Branch, localHEAD, op)).describe(rw, oldBranch, oldSHA)
+}
 
-takeEND:
-	return
+// Checks out the target branch that points at topic, or topic itself if the
+// merge was already past the branch
+func restoreTopic(rw *RepoWorker, pending *PendingTake, topic string) error {
+	if pending != nil {
+		for _, target := range pending.Targets {
+			if sha, err := rw.RevParseFull("refs/heads/" + target); err == nil && sha == topic {
+				return rw.CheckoutLocal(target)
+			}
+		}
+	}
+	return rw.CheckoutAt("DETACHED_HEAD", topic)
+}
+
+func stashWorkflow(action string, all bool, init *RepoWorkerInitializer, results chan<- *WorkFlowResult, wg *sync.WaitGroup) {
+	defer wg.Done()
+	if interrupted() {
+		return
+	}
+	rw, err := init.NewRepoWorker()
+	if err != nil {
+		results <- workerFailed(init.RepoInfo, err)
+		return
+	}
+	report := func(wfr *WorkFlowResult) {
+		results <- wfr.describe(rw, rw.Branch, "")
+	}
+	stashes, err := rw.YeetStashes()
+	if err != nil {
+		report(failedWorkflow(rw, err.Error()))
+		return
+	}
+	// Unless asked for all of them, pop and drop only touch the newest stash from the current branch
+	selected := make([]*StashEntry, 0)
+	for _, stash := range stashes {
+		if action == "list" || all {
+			selected = append(selected, stash)
+		} else if stash.Branch == rw.Branch {
+			selected = append(selected, stash)
+			break
+		}
+	}
+	// Work from the oldest entry so the stash@{n} refs of the others stay valid
+	for i := len(selected) - 1; i >= 0; i-- {
+		stash := selected[i]
+		switch action {
+		case "list":
+			report(newResult(rw.RepoInfo.Name, STASHD, fmt.Sprintf("[%s]: %s", stash.Ref, stash.Message)))
+		case "pop":
+			if err := rw.StashPop(stash.Ref); err != nil {
+				report(newResult(rw.RepoInfo.Name, CNFLCT, fmt.Sprintf("[%s]: %s did not apply", rw.Branch, stash.Ref)))
+			} else {
+				report(newResult(rw.RepoInfo.Name, PASSED, fmt.Sprintf("[%s]: popped %s", rw.Branch, stash.Ref)))
+			}
+		case "drop":
+			if err := rw.StashDrop(stash.Ref); err != nil {
+				report(failedWorkflow(rw, err.Error()))
+			} else {
+				report(newResult(rw.RepoInfo.Name, PASSED, fmt.Sprintf("[%s]: dropped %s", rw.Branch, stash.Ref)))
+			}
+		}
+	}
 }
@@ -0,0 +1,492 @@
+package workers_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	workers "yeet/workers"
+)
+
+// A repo workspace of clones of bare remotes, with a coworker clone of each
+// remote to push from. The config comes from YEET_* variables and the repo
+// list is written straight into .yeet.
+type testWorkspace struct {
+	t       *testing.T
+	dir     string
+	repoDir string
+}
+
+func newWorkspace(t *testing.T, names ...string) *testWorkspace {
+	dir := t.TempDir()
+	ws := &testWorkspace{t: t, dir: dir, repoDir: filepath.Join(dir, "src")}
+	t.Setenv("GIT_CONFIG_GLOBAL", os.DevNull)
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	t.Setenv("GIT_AUTHOR_NAME", "t")
+	t.Setenv("GIT_AUTHOR_EMAIL", "t@t")
+	t.Setenv("GIT_COMMITTER_NAME", "t")
+	t.Setenv("GIT_COMMITTER_EMAIL", "t@t")
+	t.Setenv("GIT_EDITOR", "true")
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(dir, "xdg"))
+	t.Setenv("YEET_CONFIG", "")
+	t.Setenv("YEET_FCR", "origin")
+	t.Setenv("YEET_REPODIR", ws.repoDir)
+	t.Setenv("YEET_MASTERBRANCH", "master")
+
+	list := workers.RepoList{}
+	for _, name := range names {
+		remote := filepath.Join(dir, "remote", name+".git")
+		ws.git(dir, "init", "-q", "--bare", remote)
+		coworker := ws.coworker(name)
+		ws.git(dir, "clone", "-q", remote, coworker)
+		ws.git(coworker, "symbolic-ref", "HEAD", "refs/heads/master")
+		ws.commit(coworker, "f", "base")
+		ws.git(coworker, "push", "-q", "origin", "master")
+		ws.git(dir, "clone", "-q", remote, ws.repo(name))
+		list.RepoList = append(list.RepoList, &workers.RepoInfo{Path: ws.repo(name), Name: name})
+	}
+	jsontext, _ := json.Marshal(&list)
+	listPath := filepath.Join(ws.repoDir, ".yeet", workers.RepolistFilename)
+	if err := os.MkdirAll(filepath.Dir(listPath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(listPath, jsontext, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return ws
+}
+
+func (ws *testWorkspace) repo(name string) string {
+	return filepath.Join(ws.repoDir, name)
+}
+
+func (ws *testWorkspace) coworker(name string) string {
+	return filepath.Join(ws.dir, "coworker", name)
+}
+
+func (ws *testWorkspace) git(dir string, args ...string) string {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		ws.t.Fatalf(`git %s in %s: %v: %s`, strings.Join(args, " "), dir, err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func (ws *testWorkspace) commit(dir string, file string, content string) string {
+	if err := ioutil.WriteFile(filepath.Join(dir, file), []byte(content+"\n"), 0644); err != nil {
+		ws.t.Fatal(err)
+	}
+	ws.git(dir, "add", file)
+	ws.git(dir, "commit", "-q", "-m", content)
+	return ws.git(dir, "rev-parse", "HEAD")
+}
+
+// Pushes a topic branch from the coworker clone, then moves master on so the
+// topic has to be brought onto it. With clash the two change the same file.
+func (ws *testWorkspace) pushTopic(name string, topic string, clash bool) string {
+	coworker := ws.coworker(name)
+	ws.git(coworker, "checkout", "-q", "-b", topic, "master")
+	sha := ws.commit(coworker, "f", topic)
+	ws.git(coworker, "push", "-q", "origin", topic)
+	ws.git(coworker, "checkout", "-q", "master")
+	file := "g"
+	if clash {
+		file = "f"
+	}
+	ws.commit(coworker, file, "master moves")
+	ws.git(coworker, "push", "-q", "origin", "master")
+	return sha
+}
+
+// Runs a command the way the cli does, after setting up from the workspace
+func (ws *testWorkspace) run(cmd func() error) error {
+	if err := workers.SetupCmd(); err != nil {
+		ws.t.Fatalf(`Error setting up: %v`, err)
+	}
+	defer workers.FinishCmd()
+	return cmd()
+}
+
+func (ws *testWorkspace) head(name string, rev string) string {
+	return ws.git(ws.repo(name), "rev-parse", rev)
+}
+
+func (ws *testWorkspace) remoteHead(name string, branch string) string {
+	return ws.git(ws.dir, "--git-dir", filepath.Join(ws.dir, "remote", name+".git"), "rev-parse", branch)
+}
+
+func (ws *testWorkspace) branch(name string) string {
+	return ws.git(ws.repo(name), "rev-parse", "--abbrev-ref", "HEAD")
+}
+
+func (ws *testWorkspace) upstream(name string, branch string) string {
+	return ws.git(ws.repo(name), "rev-parse", "--abbrev-ref", branch+"@{upstream}")
+}
+
+func (ws *testWorkspace) inProgress(name string) bool {
+	for _, ref := range []string{"REBASE_HEAD", "MERGE_HEAD", "CHERRY_PICK_HEAD"} {
+		cmd := exec.Command("git", "rev-parse", "-q", "--verify", ref)
+		cmd.Dir = ws.repo(name)
+		if cmd.Run() == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func TestAbortRestoresTopic(t *testing.T) {
+	for _, strategy := range []string{workers.StrategyRebase, workers.StrategyMerge, workers.StrategyCherryPick} {
+		t.Run(strategy, func(t *testing.T) {
+			ws := newWorkspace(t, "a")
+			topic := ws.pushTopic("a", "feat", true)
+			err := ws.run(func() error {
+				return workers.TakeCmd([]string{"feat"}, &workers.TakeOptions{Strategy: strategy, KeepConflicts: true})
+			})
+			if err == nil || !ws.inProgress("a") {
+				t.Fatalf(`Expected the take to stop on a conflict, got %v`, err)
+			}
+			if err := ws.run(workers.AbortCmd); err != nil {
+				t.Fatalf(`Error aborting: %v`, err)
+			}
+			if ws.inProgress("a") {
+				t.Fatalf(`Operation still in progress after abort`)
+			}
+			if branch := ws.branch("a"); branch != "feat" {
+				t.Fatalf(`Expected feat checked out, got %s`, branch)
+			}
+			if head := ws.head("a", "feat"); head != topic {
+				t.Fatalf(`Expected feat back at %s, got %s`, topic, head)
+			}
+			if upstream := ws.upstream("a", "feat"); upstream != "origin/feat" {
+				t.Fatalf(`Expected feat to track origin/feat, got %s`, upstream)
+			}
+		})
+	}
+}
+
+func TestContinueFinishesTake(t *testing.T) {
+	for _, strategy := range []string{workers.StrategyRebase, workers.StrategyCherryPick} {
+		t.Run(strategy, func(t *testing.T) {
+			ws := newWorkspace(t, "a")
+			ws.pushTopic("a", "feat", true)
+			err := ws.run(func() error {
+				return workers.TakeCmd([]string{"feat"}, &workers.TakeOptions{Strategy: strategy, KeepConflicts: true})
+			})
+			if err == nil || !ws.inProgress("a") {
+				t.Fatalf(`Expected the take to stop on a conflict, got %v`, err)
+			}
+			repo := ws.repo("a")
+			if err := ioutil.WriteFile(filepath.Join(repo, "f"), []byte("resolved\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			ws.git(repo, "add", "f")
+			if err := ws.run(workers.ContinueCmd); err != nil {
+				t.Fatalf(`Error continuing: %v`, err)
+			}
+			if ws.inProgress("a") {
+				t.Fatalf(`Operation still in progress after continue`)
+			}
+			if branch := ws.branch("a"); branch != "feat" {
+				t.Fatalf(`Expected feat checked out, got %s`, branch)
+			}
+			if base := ws.git(repo, "merge-base", "feat", "origin/master"); base != ws.head("a", "origin/master") {
+				t.Fatalf(`Expected feat on top of origin/master`)
+			}
+			if subject := ws.git(repo, "log", "-1", "--format=%s"); subject != "feat" {
+				t.Fatalf(`Expected the topic commit on top, got %s`, subject)
+			}
+			if upstream := ws.upstream("a", "feat"); upstream != "origin/feat" {
+				t.Fatalf(`Expected feat to track origin/feat, got %s`, upstream)
+			}
+		})
+	}
+}
+
+func TestTakeStrategies(t *testing.T) {
+	for _, strategy := range workers.Strategies {
+		t.Run(strategy, func(t *testing.T) {
+			ws := newWorkspace(t, "a")
+			topic := ws.pushTopic("a", "feat", false)
+			err := ws.run(func() error {
+				return workers.TakeCmd([]string{"feat"}, &workers.TakeOptions{Strategy: strategy})
+			})
+			repo := ws.repo("a")
+			master := ws.head("a", "origin/master")
+			switch strategy {
+			case workers.StrategyMerge:
+				if err != nil {
+					t.Fatalf(`Error taking: %v`, err)
+				}
+				if branch := ws.branch("a"); branch != "HEAD" {
+					t.Fatalf(`Expected a detached HEAD, got %s`, branch)
+				}
+				if parents := ws.git(repo, "log", "-1", "--format=%P"); parents != master+" "+topic {
+					t.Fatalf(`Expected a merge of feat into origin/master, got parents %s`, parents)
+				}
+			case workers.StrategyFFOnly:
+				if err == nil {
+					t.Fatalf(`Expected a diverged topic to fail to fast-forward`)
+				}
+			default:
+				if err != nil {
+					t.Fatalf(`Error taking: %v`, err)
+				}
+				if branch := ws.branch("a"); branch != "feat" {
+					t.Fatalf(`Expected feat checked out, got %s`, branch)
+				}
+				if parent := ws.head("a", "feat~1"); parent != master {
+					t.Fatalf(`Expected feat on top of origin/master`)
+				}
+				if subject := ws.git(repo, "log", "-1", "--format=%s", "feat"); subject != "feat" {
+					t.Fatalf(`Expected the topic commit on top, got %s`, subject)
+				}
+			}
+			// Only rebase and cherry-pick rewrite the topic branch itself
+			if strategy == workers.StrategyMerge || strategy == workers.StrategyFFOnly {
+				if head := ws.head("a", "feat"); head != topic {
+					t.Fatalf(`Expected feat left at %s, got %s`, topic, head)
+				}
+			}
+			if upstream := ws.upstream("a", "feat"); upstream != "origin/feat" {
+				t.Fatalf(`Expected feat to track origin/feat, got %s`, upstream)
+			}
+		})
+	}
+}
+
+func TestContinueMergeLeavesTopic(t *testing.T) {
+	ws := newWorkspace(t, "a")
+	topic := ws.pushTopic("a", "feat", true)
+	err := ws.run(func() error {
+		return workers.TakeCmd([]string{"feat"}, &workers.TakeOptions{Strategy: workers.StrategyMerge, KeepConflicts: true})
+	})
+	if err == nil || !ws.inProgress("a") {
+		t.Fatalf(`Expected the take to stop on a conflict, got %v`, err)
+	}
+	repo := ws.repo("a")
+	if err := ioutil.WriteFile(filepath.Join(repo, "f"), []byte("resolved\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ws.git(repo, "add", "f")
+	if err := ws.run(workers.ContinueCmd); err != nil {
+		t.Fatalf(`Error continuing: %v`, err)
+	}
+	if ws.inProgress("a") {
+		t.Fatalf(`Merge still in progress after continue`)
+	}
+	if branch := ws.branch("a"); branch != "HEAD" {
+		t.Fatalf(`Expected a detached HEAD, got %s`, branch)
+	}
+	master := ws.head("a", "origin/master")
+	if parents := ws.git(repo, "log", "-1", "--format=%P"); parents != master+" "+topic {
+		t.Fatalf(`Expected a merge of feat into origin/master, got parents %s`, parents)
+	}
+	if head := ws.head("a", "feat"); head != topic {
+		t.Fatalf(`Expected feat left at %s, got %s`, topic, head)
+	}
+}
+
+func TestPushTopic(t *testing.T) {
+	ws := newWorkspace(t, "a")
+	ws.pushTopic("a", "feat", false)
+	if err := ws.run(func() error { return workers.TakeCmd([]string{"feat"}, &workers.TakeOptions{}) }); err != nil {
+		t.Fatalf(`Error taking: %v`, err)
+	}
+	if err := ws.run(func() error { return workers.PushCmd("", false) }); err != nil {
+		t.Fatalf(`Error pushing: %v`, err)
+	}
+	if remote, local := ws.remoteHead("a", "feat"), ws.head("a", "feat"); remote != local {
+		t.Fatalf(`Expected the rebased feat %s pushed, remote has %s`, local, remote)
+	}
+	if upstream := ws.upstream("a", "feat"); upstream != "origin/feat" {
+		t.Fatalf(`Expected feat to track origin/feat, got %s`, upstream)
+	}
+}
+
+func TestPushKeepsCoworkersCommits(t *testing.T) {
+	ws := newWorkspace(t, "a")
+	ws.pushTopic("a", "feat", false)
+	if err := ws.run(func() error { return workers.TakeCmd([]string{"feat"}, &workers.TakeOptions{}) }); err != nil {
+		t.Fatalf(`Error taking: %v`, err)
+	}
+	// Pushed after the take fetched, so the lease no longer holds
+	coworker := ws.coworker("a")
+	ws.git(coworker, "checkout", "-q", "feat")
+	pushed := ws.commit(coworker, "h", "coworker")
+	ws.git(coworker, "push", "-q", "origin", "feat")
+	if err := ws.run(func() error { return workers.PushCmd("feat", false) }); err == nil {
+		t.Fatalf(`Expected the push over a changed remote branch to fail`)
+	}
+	if remote := ws.remoteHead("a", "feat"); remote != pushed {
+		t.Fatalf(`Expected the coworker's %s kept, remote has %s`, pushed, remote)
+	}
+}
+
+func TestPushNeverPushesBase(t *testing.T) {
+	ws := newWorkspace(t, "a")
+	before := ws.remoteHead("a", "master")
+	ws.commit(ws.repo("a"), "h", "local")
+	for _, branch := range []string{"", "master"} {
+		if err := ws.run(func() error { return workers.PushCmd(branch, false) }); err != nil {
+			t.Fatalf(`Expected the base branch skipped, got %v`, err)
+		}
+		if remote := ws.remoteHead("a", "master"); remote != before {
+			t.Fatalf(`Expected master left at %s, remote has %s`, before, remote)
+		}
+	}
+}
+
+func TestPushSetUpstream(t *testing.T) {
+	ws := newWorkspace(t, "a")
+	repo := ws.repo("a")
+	ws.git(repo, "checkout", "-q", "-b", "new")
+	local := ws.commit(repo, "h", "new")
+	if err := ws.run(func() error { return workers.PushCmd("new", true) }); err != nil {
+		t.Fatalf(`Error pushing: %v`, err)
+	}
+	if remote := ws.remoteHead("a", "new"); remote != local {
+		t.Fatalf(`Expected new pushed at %s, remote has %s`, local, remote)
+	}
+	if upstream := ws.upstream("a", "new"); upstream != "origin/new" {
+		t.Fatalf(`Expected new to track origin/new, got %s`, upstream)
+	}
+}
+
+func TestSyncLeavesLocalWork(t *testing.T) {
+	ws := newWorkspace(t, "clean", "topic", "base", "dirty")
+	for _, name := range []string{"clean", "topic", "base", "dirty"} {
+		ws.commit(ws.coworker(name), "g", "master moves")
+		ws.git(ws.coworker(name), "push", "-q", "origin", "master")
+	}
+	ws.git(ws.repo("topic"), "checkout", "-q", "-b", "feat")
+	topic := ws.commit(ws.repo("topic"), "h", "unpushed")
+	base := ws.commit(ws.repo("base"), "h", "unpushed")
+	if err := ioutil.WriteFile(filepath.Join(ws.repo("dirty"), "f"), []byte("edited\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_ = ws.run(func() error { return workers.SyncCmd(false) })
+	if head := ws.head("clean", "HEAD"); head != ws.head("clean", "origin/master") {
+		t.Fatalf(`Expected clean synced to origin/master, got %s`, head)
+	}
+	if upstream := ws.upstream("clean", "master"); upstream != "origin/master" {
+		t.Fatalf(`Expected master to track origin/master, got %s`, upstream)
+	}
+	if branch, head := ws.branch("topic"), ws.head("topic", "HEAD"); branch != "feat" || head != topic {
+		t.Fatalf(`Expected topic left on feat at %s, got %s at %s`, topic, branch, head)
+	}
+	if head := ws.head("base", "master"); head != base {
+		t.Fatalf(`Expected base left at %s, got %s`, base, head)
+	}
+	if status := ws.git(ws.repo("dirty"), "status", "--porcelain"); status != "M f" {
+		t.Fatalf(`Expected dirty left with its changes, got %q`, status)
+	}
+
+	if err := ws.run(func() error { return workers.SyncCmd(true) }); err != nil {
+		t.Fatalf(`Error syncing with --force: %v`, err)
+	}
+	for _, name := range []string{"topic", "dirty"} {
+		if branch, head := ws.branch(name), ws.head(name, "HEAD"); branch != "master" || head != ws.head(name, "origin/master") {
+			t.Fatalf(`Expected %s synced to origin/master, got %s at %s`, name, branch, head)
+		}
+	}
+	if head := ws.head("topic", "feat"); head != topic {
+		t.Fatalf(`Expected the unpushed commit kept on feat, got %s`, head)
+	}
+	if parent := ws.head("base", "master~1"); parent != ws.head("base", "origin/master") {
+		t.Fatalf(`Expected the unpushed commit on top of origin/master`)
+	}
+	if stashes := ws.git(ws.repo("dirty"), "stash", "list"); !strings.Contains(stashes, "yeet: master before sync") {
+		t.Fatalf(`Expected the changes stashed, got %q`, stashes)
+	}
+}
+
+func TestUndoRestoresRepo(t *testing.T) {
+	for _, start := range []string{"feat", "master"} {
+		t.Run(start, func(t *testing.T) {
+			ws := newWorkspace(t, "a")
+			topic := ws.pushTopic("a", "feat", false)
+			repo := ws.repo("a")
+			ws.git(repo, "fetch", "-q")
+			ws.git(repo, "branch", "-q", "--track", "feat", "origin/feat")
+			ws.git(repo, "checkout", "-q", start)
+			before := ws.head("a", "HEAD")
+			if err := ioutil.WriteFile(filepath.Join(repo, "f"), []byte("edited\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := ws.run(func() error { return workers.TakeCmd([]string{"feat"}, &workers.TakeOptions{}) }); err != nil {
+				t.Fatalf(`Error taking: %v`, err)
+			}
+			if head := ws.head("a", "feat"); head == topic {
+				t.Fatalf(`Expected the take to rebase feat`)
+			}
+			if err := ws.run(workers.UndoCmd); err != nil {
+				t.Fatalf(`Error undoing: %v`, err)
+			}
+			if branch, head := ws.branch("a"), ws.head("a", "HEAD"); branch != start || head != before {
+				t.Fatalf(`Expected %s at %s, got %s at %s`, start, before, branch, head)
+			}
+			if head := ws.head("a", "feat"); head != topic {
+				t.Fatalf(`Expected feat reset to %s, got %s`, topic, head)
+			}
+			if upstream := ws.upstream("a", "feat"); upstream != "origin/feat" {
+				t.Fatalf(`Expected feat to track origin/feat, got %s`, upstream)
+			}
+			if status := ws.git(repo, "status", "--porcelain"); status != "M f" {
+				t.Fatalf(`Expected the local changes restored, got %q`, status)
+			}
+		})
+	}
+}
+
+func TestUndoLeavesUnstashableRepo(t *testing.T) {
+	ws := newWorkspace(t, "a")
+	ws.pushTopic("a", "feat", false)
+	if err := ws.run(func() error { return workers.TakeCmd([]string{"feat"}, &workers.TakeOptions{}) }); err != nil {
+		t.Fatalf(`Error taking: %v`, err)
+	}
+	// A stash that clashes on pop leaves an unmerged path with nothing in progress
+	repo := ws.repo("a")
+	if err := ioutil.WriteFile(filepath.Join(repo, "f"), []byte("stashed\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ws.git(repo, "stash", "-q")
+	ws.commit(repo, "f", "clashes")
+	if err := exec.Command("git", "-C", repo, "stash", "pop", "-q").Run(); err == nil {
+		t.Fatalf(`Expected the stash pop to conflict`)
+	}
+	head := ws.head("a", "HEAD")
+	if err := ws.run(workers.UndoCmd); err == nil {
+		t.Fatalf(`Expected undo to fail on an unmerged path`)
+	}
+	if branch, now := ws.branch("a"), ws.head("a", "HEAD"); branch != "feat" || now != head {
+		t.Fatalf(`Expected feat left at %s, got %s at %s`, head, branch, now)
+	}
+	if status := ws.git(repo, "status", "--porcelain"); status != "UU f" {
+		t.Fatalf(`Expected the unmerged path left alone, got %q`, status)
+	}
+}
+
+func TestStartSkipsExistingBranch(t *testing.T) {
+	ws := newWorkspace(t, "a", "b")
+	ws.git(ws.repo("b"), "branch", "-q", "new")
+	existing := ws.head("b", "new")
+	workers.Filter = workers.RepoFilter{Projects: []string{"a", "b"}}
+	defer func() { workers.Filter = workers.RepoFilter{} }()
+	if err := ws.run(func() error { return workers.StartCmd("new", false) }); err != nil {
+		t.Fatalf(`Expected repos that have the branch left alone, got %v`, err)
+	}
+	if branch := ws.branch("a"); branch != "new" {
+		t.Fatalf(`Expected new checked out in a, got %s`, branch)
+	}
+	if upstream := ws.upstream("a", "new"); upstream != "origin/master" {
+		t.Fatalf(`Expected new to track origin/master, got %s`, upstream)
+	}
+	if branch, head := ws.branch("b"), ws.head("b", "new"); branch != "master" || head != existing {
+		t.Fatalf(`Expected b left on master with new at %s, got %s and %s`, existing, branch, head)
+	}
+}